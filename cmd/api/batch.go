@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// bulk versions of the create/update endpoints for catalog imports
+// items without an id are created, items with one are updated (the optional version is checked like If-Match)
+// ?mode=atomic (default) saves all of them or none, ?mode=partial saves what it can and reports the rest
+
+// outcome of one item, in the same order as the request
+type batchResult struct {
+	Index   int               `json:"index"`
+	Status  string            `json:"status"` // created, updated, failed or not_saved
+	Product *data.Product     `json:"product,omitempty"`
+	Review  *data.Review      `json:"review,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"` // validation errors
+}
+
+// reads ?mode and checks the size of the batch, partial is true for ?mode=partial
+func (a *applicationDependencies) readBatchMode(r *http.Request, v *validator.Validator, key string, items int) bool {
+	mode := a.getSingleQueryParameter(r, "mode", "atomic")
+	v.Check(items > 0, key, "must contain at least one item")
+	v.Check(items <= a.config.batch.limit, key, fmt.Sprintf("must not contain more than %d items", a.config.batch.limit))
+	return mode == "partial"
+}
+
+// the message for an error that only failed one item
+func batchItemError(err error) string {
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		return "unable to update the record due to an edit conflict"
+	case errors.Is(err, data.ErrRecordNotFound):
+		return "the requested resource could not be found"
+	default:
+		return err.Error()
+	}
+}
+
+// sends the results, in atomic mode a failed item means nothing was saved
+func (a *applicationDependencies) writeBatchResults(w http.ResponseWriter, r *http.Request, results []batchResult, partial bool) {
+	saved, failed := 0, 0
+	for _, result := range results {
+		if result.Status == "failed" {
+			failed++
+		} else {
+			saved++
+		}
+	}
+
+	if !partial && failed > 0 {
+		for i := range results {
+			if results[i].Status != "failed" {
+				results[i].Status, results[i].Product, results[i].Review = "not_saved", nil, nil
+			}
+		}
+		a.batchFailedResponse(w, r, results)
+		return
+	}
+
+	data := envelope{
+		"results": results,
+		"saved":   saved,
+		"failed":  failed,
+	}
+	err := a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// copies a validator's errors over with the item's position in front of the keys
+func addItemErrors(v *validator.Validator, prefix string, itemErrors map[string]string) {
+	for key, message := range itemErrors {
+		v.AddError(prefix+key, message)
+	}
+}
+
+func (a *applicationDependencies) batchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Products []*struct {
+			PID              *int64  `json:"pid"`
+			Version          *int32  `json:"version"`
+			Pname            *string `json:"pname"`
+			Product_Category *string `json:"product_category"`
+			Image_URL        *string `json:"image_url"`
+		} `json:"products"`
+	}
+
+	err := a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	partial := a.readBatchMode(r, v, "products", len(incomingData.Products))
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]batchResult, len(incomingData.Products))
+	products := []*data.Product{}
+	indexes := []int{}
+	for i, item := range incomingData.Products {
+		prefix := fmt.Sprintf("products[%d].", i)
+		results[i] = batchResult{Index: i}
+		iv := validator.New()
+
+		product := &data.Product{}
+		switch {
+		case item == nil:
+			iv.AddError("product", "must not be null")
+		case item.PID != nil:
+			product, err = a.productModel.Get(*item.PID)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					a.serverErrorResponse(w, r, err)
+					return
+				}
+				iv.AddError("pid", "does not exist")
+			}
+		}
+
+		if iv.IsEmpty() {
+			if item.Version != nil {
+				product.Version = *item.Version
+			}
+			if item.Pname != nil {
+				product.Pname = *item.Pname
+			}
+			if item.Product_Category != nil {
+				product.Product_Category = *item.Product_Category
+			}
+			if item.Image_URL != nil {
+				product.Image_URL = *item.Image_URL
+			}
+			data.ValidateProduct(iv, product)
+		}
+
+		if !iv.IsEmpty() {
+			results[i].Status, results[i].Errors = "failed", iv.Errors
+			addItemErrors(v, prefix, iv.Errors)
+			continue
+		}
+		products = append(products, product)
+		indexes = append(indexes, i)
+	}
+
+	// in atomic mode nothing is written unless every item is valid
+	if !partial && !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	itemErrors, err := a.productModel.SaveBatch(products, partial)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for j, i := range indexes {
+		switch {
+		case itemErrors[j] != nil:
+			results[i].Status, results[i].Error = "failed", batchItemError(itemErrors[j])
+		case incomingData.Products[i].PID == nil:
+			results[i].Status, results[i].Product = "created", products[j]
+		default:
+			results[i].Status, results[i].Product = "updated", products[j]
+		}
+	}
+
+	a.writeBatchResults(w, r, results, partial)
+}
+
+func (a *applicationDependencies) batchReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Reviews []*struct {
+			RID     *int64   `json:"rid"`
+			Version *int32   `json:"version"`
+			Prod_ID *int64   `json:"prod_id"`
+			Author  *string  `json:"author"`
+			Variant *string  `json:"variant"`
+			Rating  *int8    `json:"rating"`
+			Body    *string  `json:"body"`
+			Pros    []string `json:"pros"`
+			Cons    []string `json:"cons"`
+			// from the storefront's order, needed for the verified purchase badge
+			Review_Token *string `json:"review_token"`
+		} `json:"reviews"`
+	}
+
+	err := a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	partial := a.readBatchMode(r, v, "reviews", len(incomingData.Reviews))
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]batchResult, len(incomingData.Reviews))
+	reviews := []*data.Review{}
+	indexes := []int{}
+	for i, item := range incomingData.Reviews {
+		prefix := fmt.Sprintf("reviews[%d].", i)
+		results[i] = batchResult{Index: i}
+		iv := validator.New()
+
+		review := &data.Review{}
+		switch {
+		case item == nil:
+			iv.AddError("review", "must not be null")
+		case item.RID != nil:
+			// the product, author and purchase of an existing review can't be changed
+			iv.Check(item.Prod_ID == nil, "prod_id", "can only be set when creating a review")
+			iv.Check(item.Author == nil, "author", "can only be set when creating a review")
+			iv.Check(item.Variant == nil, "variant", "can only be set when creating a review")
+			iv.Check(item.Review_Token == nil, "review_token", "can only be set when creating a review")
+			review, err = a.reviewModel.GetAny(*item.RID)
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					a.serverErrorResponse(w, r, err)
+					return
+				}
+				iv.AddError("rid", "does not exist")
+			}
+		default:
+			if item.Prod_ID != nil {
+				review.Prod_ID = *item.Prod_ID
+			}
+			if item.Author != nil {
+				review.Author = *item.Author
+			}
+			if item.Variant != nil {
+				review.Variant = *item.Variant
+			}
+			if item.Review_Token != nil {
+				review.Review_Token = *item.Review_Token
+			}
+		}
+
+		if iv.IsEmpty() {
+			if item.Version != nil {
+				review.Version = *item.Version
+			}
+			if item.Rating != nil {
+				review.Rating = *item.Rating
+			}
+			if item.Body != nil {
+				review.Body = *item.Body
+			}
+			// a nil slice means the list wasn't sent, an empty one clears it
+			if item.Pros != nil {
+				review.Pros = item.Pros
+			}
+			if item.Cons != nil {
+				review.Cons = item.Cons
+			}
+			data.ValidateReview(iv, review, a.reviewModel)
+		}
+
+		if !iv.IsEmpty() {
+			results[i].Status, results[i].Errors = "failed", iv.Errors
+			addItemErrors(v, prefix, iv.Errors)
+			continue
+		}
+		reviews = append(reviews, review)
+		indexes = append(indexes, i)
+	}
+
+	// in atomic mode nothing is written unless every item is valid
+	if !partial && !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	itemErrors, err := a.reviewModel.SaveBatch(reviews, partial)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for j, i := range indexes {
+		switch {
+		case itemErrors[j] != nil:
+			results[i].Status, results[i].Error = "failed", batchItemError(itemErrors[j])
+		case incomingData.Reviews[i].RID == nil:
+			results[i].Status, results[i].Review = "created", reviews[j]
+		default:
+			results[i].Status, results[i].Review = "updated", reviews[j]
+		}
+	}
+
+	a.writeBatchResults(w, r, results, partial)
+}
+
+// POST /v1/review/batch and the helpful vote (POST /v1/review/:id) have to share a route
+// since httprouter doesn't allow a static segment next to a wildcard
+func (a *applicationDependencies) reviewPostHandler(w http.ResponseWriter, r *http.Request) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "batch" {
+		a.batchReviewsHandler(w, r)
+		return
+	}
+	a.updateHelpfulCountHandler(w, r)
+}
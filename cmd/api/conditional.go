@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+)
+
+// conditional GET support so the frontend can keep polling without downloading unchanged pages
+// a single record's ETag is "<version>-<updated_at in microseconds as hex>", the version part is
+// what If-Match compares (see ifMatch) and updated_at covers changes that don't bump the version
+// like helpful votes, moderation and avg_rating
+
+func productETag(product *data.Product) string {
+	return fmt.Sprintf(`"%d-%x"`, product.Version, product.UpdatedAt.UnixMicro())
+}
+
+// a review also carries the name of its product, renaming the product doesn't touch the review
+// so a hash of the name is added to the tag
+func reviewETag(review *data.Review) string {
+	name := fnv.New32a()
+	name.Write([]byte(review.ProductName))
+	return fmt.Sprintf(`"%d-%x-%x"`, review.Version, review.UpdatedAt.UnixMicro(), name.Sum32())
+}
+
+// a list's ETag is a hash of the ETags of everything on the page plus the metadata,
+// so adding, removing or reordering records changes it as well
+// the negotiated media type is hashed in too, a page of JSON and the same page as CSV are
+// different bytes and can't share a strong ETag
+func listETag(r *http.Request, metadata data.Metadata, tags []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", rendererFor(r).contentType)
+	fmt.Fprintf(h, "%d/%d/%d/%d/%d", metadata.CurrentPage, metadata.PageSize,
+		metadata.FirstPage, metadata.LastPage, metadata.TotalRecords)
+	for _, tag := range tags {
+		h.Write([]byte(tag))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+// the validator headers for a response, lastModified is left out when it's zero
+// no-cache lets clients store the response but makes them check back with us before reusing it
+// the ETag gets the format's suffix so the JSON and XML (etc) copies of a record don't match each other
+func cacheHeaders(r *http.Request, headers http.Header, etag string, lastModified time.Time) http.Header {
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("ETag", representationETag(r, etag))
+	headers.Set("Cache-Control", "no-cache")
+	if !lastModified.IsZero() {
+		headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	return headers
+}
+
+// checks If-None-Match (or If-Modified-Since when there's no If-None-Match) against the
+// validators in headers, if the client's copy is still current a 304 is sent and true is returned
+func (a *applicationDependencies) notModified(w http.ResponseWriter, r *http.Request, headers http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagListMatches(inm, headers.Get("ETag")) {
+			return false
+		}
+	} else {
+		ims := r.Header.Get("If-Modified-Since")
+		lastModified := headers.Get("Last-Modified")
+		if ims == "" || lastModified == "" {
+			return false
+		}
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		if err != nil || modified.After(since) {
+			return false
+		}
+	}
+
+	err := a.writeResponse(w, r, http.StatusNotModified, nil, headers)
+	if err != nil {
+		a.logger.Error(err.Error())
+	}
+	return true
+}
+
+// If-None-Match uses weak comparison so W/"x" matches "x"
+func etagListMatches(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/webhook"
+)
+
+// the webhook dispatcher sends the deliveries the outbox relay queues (see outbox.go) in the background
+// the queue is the webhook_deliveries table so nothing is lost on a restart, a delivery that was
+// being sent when the process died is sent again once its lease runs out
+
+// how many due deliveries are sent at the same time
+const webhookClaimLimit = 20
+
+func (a *applicationDependencies) runWebhookDispatcher() {
+	ticker := time.NewTicker(a.config.webhooks.poll)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.dispatchWebhooks()
+	}
+}
+
+// sends everything that's due, a batch at a time
+func (a *applicationDependencies) dispatchWebhooks() {
+	// a claimed delivery is left alone for longer than an attempt can take
+	lease := a.config.webhooks.timeout + time.Minute
+
+	for {
+		deliveries, err := a.webhookModel.ClaimDue(webhookClaimLimit, lease)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery *data.WebhookDelivery) {
+				defer wg.Done()
+				err := a.deliverWebhook(delivery)
+				if err != nil {
+					a.logger.Error(err.Error(), "delivery", delivery.ID)
+				}
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookClaimLimit {
+			return
+		}
+	}
+}
+
+// makes one attempt at a delivery and records it, a failed attempt is retried with exponential
+// backoff until it's out of attempts
+// any 2xx response counts as delivered, everything else (including redirects) is a failure
+func (a *applicationDependencies) deliverWebhook(delivery *data.WebhookDelivery) error {
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("building webhook request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "reviews-webhooks/"+appVersion)
+	request.Header.Set("X-Webhook-ID", delivery.Event_ID)
+	request.Header.Set("X-Webhook-Event", delivery.Event_Type)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+
+	start := time.Now()
+	request.Header.Set(webhook.SignatureHeader, webhook.Sign(delivery.Secret, start, delivery.Payload))
+
+	response, err := a.webhookClient.Do(request)
+	attempt := &data.WebhookAttempt{AttemptedAt: start, Duration_MS: int(time.Since(start).Milliseconds())}
+	succeeded := false
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		// the body isn't used but reading it lets the connection be reused
+		io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		response.Body.Close()
+		attempt.Status_Code = &response.StatusCode
+		succeeded = response.StatusCode >= 200 && response.StatusCode < 300
+		if !succeeded {
+			attempt.Error = "unexpected response " + response.Status
+		}
+	}
+
+	var retryAt *time.Time
+	if !succeeded && delivery.Attempts+1 < a.config.webhooks.attempts {
+		next := time.Now().Add(webhook.Backoff(delivery.Attempts+1, a.config.webhooks.backoff, a.config.webhooks.maxBackoff))
+		retryAt = &next
+	}
+	return a.webhookModel.RecordAttempt(delivery, attempt, succeeded, retryAt)
+}
@@ -1,24 +1,61 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
 )
 
 func (a *applicationDependencies) logError(r *http.Request, err error) {
 
 	method := r.Method
 	uri := r.URL.RequestURI()
-	a.logger.Error(err.Error(), "method", method, "uri", uri)
+	a.logger.Error(err.Error(), "method", method, "uri", uri, "request_id", requestIDFor(r))
 
 }
 
-func (a *applicationDependencies) errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
-	errorData := envelope{"error": message}
-	err := a.writeJson(w, status, errorData, nil)
+// errors are sent as problem details (RFC 7807), application/problem+json for JSON clients
+// code is the stable name of the error, clients should switch on it rather than on detail
+// which is only meant for people, it's also the last part of the type URI
+// request_id is the same as the X-Request-ID header, support can find the request in the logs with it
+const problemTypeBase = "/problems/"
+
+// extra holds the problem's extension members (duplicate_of, errors, ...)
+func (a *applicationDependencies) problemResponse(w http.ResponseWriter, r *http.Request, status int, code string, title string, detail string, extra envelope) {
+	problem := envelope{
+		"type":       problemTypeBase + code,
+		"title":      title,
+		"status":     status,
+		"detail":     detail,
+		"instance":   r.URL.RequestURI(),
+		"code":       code,
+		"request_id": requestIDFor(r),
+	}
+	for key, value := range extra {
+		problem[key] = value
+	}
+
+	rend, body, err := renderBody(r, problem)
+	if errors.Is(err, errNotTabular) {
+		// a problem is sent even to a client that only takes CSV, as JSON
+		rend = jsonRenderer
+		body, err = rend.render(problem)
+	}
 	if err != nil {
 		a.logError(r, err)
 		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", rend.problemType)
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	if err != nil {
+		a.logError(r, err)
 	}
 }
 
@@ -26,26 +63,157 @@ func (a *applicationDependencies) serverErrorResponse(w http.ResponseWriter, r *
 	a.logError(r, err)
 
 	message := "the server encountered a problem and could not proccess your request"
-	a.errorResponseJSON(w, r, http.StatusInternalServerError, message)
+	a.problemResponse(w, r, http.StatusInternalServerError, "server_error", "Internal server error", message, nil)
 }
 
 func (a *applicationDependencies) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	a.errorResponseJSON(w, r, http.StatusNotFound, message)
+	a.problemResponse(w, r, http.StatusNotFound, "not_found", "Resource not found", message, nil)
 }
 
 func (a *applicationDependencies) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
 
-	a.errorResponseJSON(w, r, http.StatusMethodNotAllowed, message)
+	a.problemResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", message, nil)
 }
 
 // 400 Bad Request Response
 // sends an error response if our client messes up with 400 (bad request)
 func (a *applicationDependencies) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	a.errorResponseJSON(w, r, http.StatusBadRequest, err.Error())
+	a.problemResponse(w, r, http.StatusBadRequest, "bad_request", "Malformed request", err.Error(), nil)
+}
+
+// 401 Unauthorized Response
+// the bearer token is missing or doesn't match the one the server was started with
+func (a *applicationDependencies) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	a.problemResponse(w, r, http.StatusUnauthorized, "invalid_token", "Invalid authentication token", message, nil)
+}
+
+// 406 Not Acceptable Response
+// the Accept header only lists formats we can't produce, the error itself is sent as JSON
+func (a *applicationDependencies) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested representation is not available"
+	a.problemResponse(w, r, http.StatusNotAcceptable, "not_acceptable", "Representation not available", message, envelope{
+		"available": []string{"application/json", "application/vnd.reviews.compact+json", "text/csv", "application/xml", "application/msgpack"},
+	})
+}
+
+// 415 Unsupported Media Type Response
+// the body's Content-Type isn't one the endpoint reads, accepted lists the ones it does
+func (a *applicationDependencies) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, accepted string) {
+	if r.Method == http.MethodPatch {
+		w.Header().Set("Accept-Patch", accepted)
+	}
+
+	message := fmt.Sprintf("the %q content type is not supported, use one of %s", r.Header.Get("Content-Type"), accepted)
+	a.problemResponse(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported media type", message, nil)
+}
+
+// 409 Conflict Response
+// a "test" operation of a JSON Patch didn't match the record
+func (a *applicationDependencies) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	a.problemResponse(w, r, http.StatusConflict, "patch_test_failed", "Patch test failed", err.Error(), nil)
+}
+
+// 422 Unprocessable Entity Response
+// the JSON Patch is well formed but can't be applied to the record (e.g. a path that isn't there)
+func (a *applicationDependencies) patchNotApplicableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	a.problemResponse(w, r, http.StatusUnprocessableEntity, "patch_not_applicable", "Patch cannot be applied", err.Error(), nil)
 }
 
+// 409 Conflict Response
+// the review was rejected as a copy of an existing one, the client gets a link to it
+func (a *applicationDependencies) duplicateReviewResponse(w http.ResponseWriter, r *http.Request, err *data.DuplicateReviewError) {
+	message := "the review is a duplicate of an existing review"
+	a.problemResponse(w, r, http.StatusConflict, "duplicate_review", "Duplicate review", message, envelope{
+		"duplicate_of": fmt.Sprintf("/v1/review/%d", err.DuplicateOf),
+		"same_author":  err.SameAuthor,
+	})
+}
+
+// 409 Conflict Response
+// someone else changed the record between us reading and updating it
+func (a *applicationDependencies) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	a.problemResponse(w, r, http.StatusConflict, "edit_conflict", "Edit conflict", message, nil)
+}
+
+// 412 Precondition Failed Response
+// the If-Match header doesn't match the current version of the record
+func (a *applicationDependencies) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since it was last read, fetch it again before updating"
+	a.problemResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", "Precondition failed", message, nil)
+}
+
+// 409 Conflict Response
+// a retry arrived while the first request with the same Idempotency-Key is still being handled
+func (a *applicationDependencies) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key is still being processed, try again shortly"
+	a.problemResponse(w, r, http.StatusConflict, "idempotency_key_in_use", "Idempotency key in use", message, nil)
+}
+
+// 422 Unprocessable Entity Response
+// the Idempotency-Key was already used for a request with a different body
+func (a *applicationDependencies) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key has already been used with a different request body, query string or Accept header"
+	a.problemResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_mismatch", "Idempotency key reused", message, nil)
+}
+
+// 409 Conflict Response
+// an atomic batch was rolled back because one of its items couldn't be saved
+func (a *applicationDependencies) batchFailedResponse(w http.ResponseWriter, r *http.Request, results []batchResult) {
+	message := "the batch was not saved because at least one item failed"
+	a.problemResponse(w, r, http.StatusConflict, "batch_failed", "Batch failed", message, envelope{
+		"results": results,
+	})
+}
+
+// one entry of a validation problem's errors
+// the field is a JSON pointer (RFC 6901) into the request body, or the name of the
+// query string parameter when the error is about one (always for GET, there is no body)
+type fieldError struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+	Detail    string `json:"detail"`
+}
+
+// 422 Unprocessable Entity Response
 func (a *applicationDependencies) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
+	fieldErrors := make([]fieldError, 0, len(errors))
+	query := r.URL.Query()
+	for key, message := range errors {
+		if _, inQuery := query[key]; inQuery || r.Method == http.MethodGet {
+			fieldErrors = append(fieldErrors, fieldError{Parameter: key, Detail: message})
+		} else {
+			fieldErrors = append(fieldErrors, fieldError{Pointer: jsonPointer(key), Detail: message})
+		}
+	}
+	a.fieldErrorsResponse(w, r, fieldErrors)
+}
+
+// 422 for errors that already know where they are (the request validation knows whether a
+// name is a path parameter, a query parameter or a field of the body)
+func (a *applicationDependencies) fieldErrorsResponse(w http.ResponseWriter, r *http.Request, fieldErrors []fieldError) {
+	// map order is random, sorted so the same request always gets the same body
+	sort.Slice(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Pointer+fieldErrors[i].Parameter < fieldErrors[j].Pointer+fieldErrors[j].Parameter
+	})
+
+	message := "the request contains invalid fields"
+	a.problemResponse(w, r, http.StatusUnprocessableEntity, "validation_failed", "Validation failed", message, envelope{
+		"errors": fieldErrors,
+	})
+}
+
+// turns a validator key like "reviews[2].rating" into the pointer "/reviews/2/rating"
+func jsonPointer(key string) string {
+	key = strings.ReplaceAll(key, "]", "")
+	parts := strings.FieldsFunc(key, func(c rune) bool { return c == '.' || c == '[' })
+	for i, part := range parts {
+		parts[i] = strings.NewReplacer("~", "~0", "/", "~1").Replace(part)
+	}
+	return "/" + strings.Join(parts, "/")
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+)
+
+// full dumps for the analysts, the rows are written out as they're read from the database
+// instead of being loaded into a slice like the list endpoints do
+// the format comes from ?format= or the Accept header (csv, jsonl or ndjson, jsonl by default)
+// and the response is gzipped when the client sends Accept-Encoding: gzip
+
+// content types of the export formats
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"jsonl":  "application/jsonl",
+	"ndjson": "application/x-ndjson",
+}
+
+// the format from ?format= or, without it, the first one the Accept header asks for
+func (a *applicationDependencies) readExportFormat(r *http.Request) string {
+	format := a.getSingleQueryParameter(r, "format", "")
+	if format != "" {
+		return format
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		switch strings.TrimSpace(strings.ToLower(mediaType)) {
+		case "text/csv":
+			return "csv"
+		case "application/x-ndjson":
+			return "ndjson"
+		case "application/jsonl", "application/x-jsonlines":
+			return "jsonl"
+		}
+	}
+	return "jsonl"
+}
+
+// true when Accept-Encoding lists gzip (and doesn't turn it off with q=0)
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		if strings.TrimSpace(strings.ToLower(name)) != "gzip" {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// writes the rows of an export in the chosen format and flushes them to the client as it goes
+type exportStream struct {
+	rc   *http.ResponseController
+	gz   *gzip.Writer
+	csv  *csv.Writer
+	json *json.Encoder
+	rows int
+}
+
+// sends the headers and gets the response ready for the rows
+// the server's write timeout is lifted since a full dump can take a while
+func (a *applicationDependencies) startExport(w http.ResponseWriter, r *http.Request, name string, format string, header []string) (*exportStream, error) {
+	stream := &exportStream{rc: http.NewResponseController(w)}
+	err := stream.rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+format+`"`)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		stream.gz = gzip.NewWriter(w)
+		out = stream.gz
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if format == "csv" {
+		stream.csv = csv.NewWriter(out)
+		return stream, stream.csv.Write(header)
+	}
+	stream.json = json.NewEncoder(out)
+	return stream, nil
+}
+
+// writes one row, record for CSV and value for JSON Lines
+func (s *exportStream) write(record []string, value any) error {
+	var err error
+	if s.csv != nil {
+		err = s.csv.Write(record)
+	} else {
+		err = s.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.rows++
+	if s.rows%100 == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *exportStream) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return s.rc.Flush()
+}
+
+// flushes whatever is left and finishes the gzip stream
+func (s *exportStream) close() error {
+	err := s.flush()
+	if err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// once the rows have started the status can't be changed anymore, the connection is dropped
+// instead so the client sees a broken download rather than a dump that looks complete
+func (a *applicationDependencies) abortExport(r *http.Request, err error) {
+	a.logError(r, err)
+	panic(http.ErrAbortHandler)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (a *applicationDependencies) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	pname, productCategory, avgRating := a.readProductQuery(r)
+	format := a.readExportFormat(r)
+
+	stream, err := a.startExport(w, r, "products", format, []string{
+		"pid", "pname", "product_category", "image_url", "avg_rating", "version", "created_at", "updated_at",
+	})
+	if err != nil {
+		a.abortExport(r, err)
+	}
+
+	err = a.productModel.Export(r.Context(), pname, productCategory, avgRating, func(product *data.Product) error {
+		return stream.write([]string{
+			strconv.FormatInt(product.PID, 10),
+			product.Pname,
+			product.Product_Category,
+			product.Image_URL,
+			strconv.FormatFloat(float64(product.Avg_Rating), 'f', 2, 32),
+			strconv.Itoa(int(product.Version)),
+			formatTime(&product.CreatedAt),
+			formatTime(&product.UpdatedAt),
+		}, product)
+	})
+	if err == nil {
+		err = stream.close()
+	}
+	if err != nil {
+		a.abortExport(r, err)
+	}
+}
+
+func (a *applicationDependencies) exportReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	q := a.readReviewQuery(r, v)
+	format := a.readExportFormat(r)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stream, err := a.startExport(w, r, "reviews", format, []string{
+		"rid", "prod_id", "product_name", "rating", "helpful_count", "body", "pros", "cons", "author",
+		"verified_purchase", "status", "sentiment", "sentiment_score", "sentiment_mismatch", "language",
+		"edited_at", "version", "created_at",
+	})
+	if err != nil {
+		a.abortExport(r, err)
+	}
+
+	err = a.reviewModel.Export(r.Context(), q, func(review *data.Review) error {
+		score := ""
+		if review.Score != nil {
+			score = strconv.FormatFloat(*review.Score, 'f', -1, 64)
+		}
+		// pros and cons use the same | separator cmd/importer reads by default
+		return stream.write([]string{
+			strconv.FormatInt(review.RID, 10),
+			strconv.FormatInt(review.Prod_ID, 10),
+			review.ProductName,
+			strconv.Itoa(int(review.Rating)),
+			strconv.Itoa(review.Helpful_Count),
+			review.Body,
+			strings.Join(review.Pros, "|"),
+			strings.Join(review.Cons, "|"),
+			review.Author,
+			strconv.FormatBool(review.Verified),
+			review.Status,
+			review.Sentiment,
+			score,
+			strconv.FormatBool(review.Mismatch),
+			review.Language,
+			formatTime(review.EditedAt),
+			strconv.Itoa(int(review.Version)),
+			formatTime(&review.CreatedAt),
+		}, review)
+	})
+	if err == nil {
+		err = stream.close()
+	}
+	if err != nil {
+		a.abortExport(r, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+)
+
+// ?fields=pid,pname,avg_rating picks the fields of the products that are sent (only those
+// columns are read from the database) and ?include=reviews,rating_summary embeds related data
+// so the product page doesn't need another round trip for it
+
+// how many of the latest reviews are embedded in each product
+const embeddedReviews = 5
+
+// reads a comma separated list like ?fields= without repeats, the entries were already
+// checked against the enum of the parameter in openapi.json
+func (a *applicationDependencies) getListParameter(r *http.Request, key string) []string {
+	var list []string
+	for _, entry := range a.getMultipleQueryParameters(r, key, nil) {
+		if !validator.PermittedValue(entry, list...) {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// keeps only the picked fields of a record, the keys are the json names of its struct fields
+func selectFields(record any, fields []string) envelope {
+	value := reflect.Indirect(reflect.ValueOf(record))
+	selected := envelope{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if len(fields) == 0 || validator.PermittedValue(name, fields...) {
+			selected[name] = value.Field(i).Interface()
+		}
+	}
+	return selected
+}
+
+// the products as they're sent with their ETags, when there's no ?fields= or ?include= that's
+// the products themselves, otherwise a map per product with the picked fields and the embedded data
+// the embedded data doesn't change the product's updated_at so it's hashed into the ETag
+// (with the version kept in front for If-Match)
+func (a *applicationDependencies) productRepresentations(products []*data.Product, fields []string, includes []string) ([]any, []string, error) {
+	items := make([]any, len(products))
+	tags := make([]string, len(products))
+	if len(fields) == 0 && len(includes) == 0 {
+		for i, product := range products {
+			items[i], tags[i] = product, productETag(product)
+		}
+		return items, tags, nil
+	}
+
+	ids := make([]int64, len(products))
+	for i, product := range products {
+		ids[i] = product.PID
+	}
+	var latest map[int64][]*data.Review
+	var summaries map[int64]*data.RatingSummary
+	var err error
+	if validator.PermittedValue("reviews", includes...) {
+		latest, err = a.reviewModel.Latest(ids, embeddedReviews)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if validator.PermittedValue("rating_summary", includes...) {
+		summaries, err = a.reviewModel.RatingSummaries(ids)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, product := range products {
+		item := selectFields(product, fields)
+		h := sha256.New()
+		fmt.Fprintf(h, "%s/%v/%v", productETag(product), fields, includes)
+		if latest != nil {
+			item["reviews"] = latest[product.PID]
+			for _, review := range latest[product.PID] {
+				h.Write([]byte(reviewETag(review)))
+			}
+		}
+		if summaries != nil {
+			item["rating_summary"] = summaries[product.PID]
+			fmt.Fprintf(h, "%v", *summaries[product.PID])
+		}
+		items[i] = item
+		tags[i] = fmt.Sprintf(`"%d-%s"`, product.Version, hex.EncodeToString(h.Sum(nil))[:16])
+	}
+	return items, tags, nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// POST /v1/graphql answers the queries of schema.graphql, the resolvers use the same models and
+// validation as the JSON API
+// errors are in the "errors" list of the response with the code of the matching problem details
+// in their extensions (not_found, validation_failed, edit_conflict...)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+// queries nested deeper than this are refused before anything is resolved
+const graphqlMaxDepth = 8
+
+// parses the schema and checks it against the resolvers, panics like routes() does on a mismatch
+func (a *applicationDependencies) newGraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &graphqlResolver{a},
+		graphql.MaxDepth(graphqlMaxDepth))
+}
+
+func (a *applicationDependencies) graphqlHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var incomingData struct {
+			Query         string         `json:"query"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+			Extensions    map[string]any `json:"extensions"`
+		}
+		err := a.readJson(w, r, &incomingData)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+
+		response := schema.Exec(r.Context(), incomingData.Query, incomingData.OperationName, incomingData.Variables)
+
+		// the client only sees server_error, what went wrong goes to the log
+		for _, queryError := range response.Errors {
+			var gqlErr *graphqlError
+			if errors.As(queryError.ResolverError, &gqlErr) && gqlErr.cause != nil {
+				a.logError(r, gqlErr.cause)
+			}
+		}
+
+		// the GraphQL response is always JSON, with a 200 even when it has errors
+		js, err := json.Marshal(response)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+// an error with the code of the problem details the JSON API would send for it
+type graphqlError struct {
+	code    string
+	message string
+	extra   map[string]any
+	cause   error // logged, never sent
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.code}
+	for key, value := range e.extra {
+		extensions[key] = value
+	}
+	return extensions
+}
+
+func graphqlValidationError(v *validator.Validator) error {
+	return &graphqlError{
+		code:    "validation_failed",
+		message: "the request contains invalid fields",
+		extra:   map[string]any{"errors": v.Errors},
+	}
+}
+
+// the GraphQL version of the error responses in errors.go
+func graphqlFailure(err error) error {
+	var duplicateError *data.DuplicateReviewError
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return &graphqlError{code: "not_found", message: "the requested resource could not be found"}
+	case errors.Is(err, data.ErrEditConflict):
+		return &graphqlError{code: "edit_conflict", message: "unable to update the record due to an edit conflict, please try again"}
+	case errors.As(err, &duplicateError):
+		return &graphqlError{
+			code:    "duplicate_review",
+			message: "the review is a duplicate of an existing review",
+			extra: map[string]any{
+				"duplicate_of": strconv.FormatInt(duplicateError.DuplicateOf, 10),
+				"same_author":  duplicateError.SameAuthor,
+			},
+		}
+	default:
+		return &graphqlError{
+			code:    "server_error",
+			message: "the server encountered a problem and could not proccess your request",
+			cause:   err,
+		}
+	}
+}
+
+// ids are strings in GraphQL
+func graphqlID(id graphql.ID, key string, v *validator.Validator) int64 {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	v.Check(err == nil && n > 0, key, "must be a valid id")
+	return n
+}
+
+func intOr(n *int32, defaultValue int) int {
+	if n == nil {
+		return defaultValue
+	}
+	return int(*n)
+}
+
+func stringOr(s *string, defaultValue string) string {
+	if s == nil {
+		return defaultValue
+	}
+	return *s
+}
+
+type graphqlResolver struct {
+	a *applicationDependencies
+}
+
+func (q *graphqlResolver) Product(args struct{ PID graphql.ID }) (*productResolver, error) {
+	v := validator.New()
+	id := graphqlID(args.PID, "pid", v)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	product, err := q.a.productModel.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, graphqlFailure(err)
+	}
+	return newProductGroup(q.a, []*data.Product{product})[0], nil
+}
+
+func (q *graphqlResolver) Products(args struct {
+	Pname            string
+	Product_Category string
+	Avg_Rating       float64
+	Page             int32
+	Page_Size        int32
+	Sort             string
+}) (*productListResolver, error) {
+	filters := data.Filters{
+		Page:         int(args.Page),
+		PageSize:     int(args.Page_Size),
+		Sort:         args.Sort,
+		SortSafeList: data.ProductSortSafeList,
+	}
+	v := validator.New()
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	products, metadata, err := q.a.productModel.GetAll(args.Pname, args.Product_Category,
+		float32(args.Avg_Rating), nil, filters)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return &productListResolver{newProductGroup(q.a, products), metadata}, nil
+}
+
+func (q *graphqlResolver) Review(args struct{ RID graphql.ID }) (*reviewResolver, error) {
+	v := validator.New()
+	id := graphqlID(args.RID, "rid", v)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	review, err := q.a.reviewModel.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, graphqlFailure(err)
+	}
+	return newReviewGroup(q.a, []*data.Review{review})[0], nil
+}
+
+func (q *graphqlResolver) Reviews(args struct {
+	Prod_ID       *graphql.ID
+	Rating        *int32
+	Helpful_Count *int32
+	Verified      bool
+	Sentiment     *string
+	Q             *string
+	Lang          *string
+	Page          int32
+	Page_Size     int32
+	Sort          string
+}) (*reviewListResolver, error) {
+	v := validator.New()
+	var query data.ReviewQuery
+	if args.Prod_ID != nil {
+		query.Prod_ID = int(graphqlID(*args.Prod_ID, "prod_id", v))
+	}
+	query.Rating = intOr(args.Rating, 0)
+	query.Helpful_Count = intOr(args.Helpful_Count, 0)
+	query.Verified = args.Verified
+	query.Sentiment = stringOr(args.Sentiment, "")
+	query.Search = stringOr(args.Q, "")
+	query.Language = stringOr(args.Lang, "")
+	data.ValidateReviewQuery(v, query)
+
+	filters := data.Filters{
+		Page:         int(args.Page),
+		PageSize:     int(args.Page_Size),
+		Sort:         args.Sort,
+		SortSafeList: data.ReviewSortSafeList,
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	reviews, metadata, err := q.a.reviewModel.GetAll(query, filters)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return &reviewListResolver{newReviewGroup(q.a, reviews), metadata}, nil
+}
+
+func (q *graphqlResolver) CreateReview(args struct {
+	Input struct {
+		Prod_ID      graphql.ID
+		Rating       int32
+		Body         *string
+		Pros         *[]string
+		Cons         *[]string
+		Author       *string
+		Variant      *string
+		Review_Token *string
+	}
+}) (*reviewResolver, error) {
+	v := validator.New()
+	review := &data.Review{
+		Prod_ID:      graphqlID(args.Input.Prod_ID, "prod_id", v),
+		Rating:       reviewRating(args.Input.Rating, v),
+		Body:         stringOr(args.Input.Body, ""),
+		Author:       stringOr(args.Input.Author, ""),
+		Variant:      stringOr(args.Input.Variant, ""),
+		Review_Token: stringOr(args.Input.Review_Token, ""),
+	}
+	if args.Input.Pros != nil {
+		review.Pros = *args.Input.Pros
+	}
+	if args.Input.Cons != nil {
+		review.Cons = *args.Input.Cons
+	}
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	data.ValidateReview(v, review, q.a.reviewModel)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	err := q.a.reviewModel.Insert(review)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return newReviewGroup(q.a, []*data.Review{review})[0], nil
+}
+
+func (q *graphqlResolver) UpdateReview(args struct {
+	RID     graphql.ID
+	Version *int32
+	Input   struct {
+		Rating *int32
+		Body   *string
+		Pros   *[]string
+		Cons   *[]string
+	}
+}) (*reviewResolver, error) {
+	v := validator.New()
+	id := graphqlID(args.RID, "rid", v)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	review, err := q.a.reviewModel.GetAny(id)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+
+	if !versionMatches(args.Version, review.Version) {
+		return nil, graphqlFailure(data.ErrEditConflict)
+	}
+
+	if args.Input.Rating != nil {
+		review.Rating = reviewRating(*args.Input.Rating, v)
+	}
+	if args.Input.Body != nil {
+		review.Body = *args.Input.Body
+	}
+	if args.Input.Pros != nil {
+		review.Pros = *args.Input.Pros
+	}
+	if args.Input.Cons != nil {
+		review.Cons = *args.Input.Cons
+	}
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	data.ValidateReview(v, review, q.a.reviewModel)
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	err = q.a.reviewModel.Update(review)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return newReviewGroup(q.a, []*data.Review{review})[0], nil
+}
+
+func (q *graphqlResolver) DeleteReview(args struct{ RID graphql.ID }) (bool, error) {
+	v := validator.New()
+	id := graphqlID(args.RID, "rid", v)
+	if !v.IsEmpty() {
+		return false, graphqlValidationError(v)
+	}
+
+	err := q.a.reviewModel.Delete(id)
+	if err != nil {
+		return false, graphqlFailure(err)
+	}
+	return true, nil
+}
+
+func (q *graphqlResolver) VoteReview(args struct {
+	RID       graphql.ID
+	Increment int32
+}) (*reviewResolver, error) {
+	v := validator.New()
+	id := graphqlID(args.RID, "rid", v)
+	v.Check(args.Increment == 1 || args.Increment == -1, "increment", "must be either 1 or -1")
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	err := q.a.reviewModel.UpdateHelpfulCount(id, int8(args.Increment))
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+
+	// the review with its new count
+	review, err := q.a.reviewModel.Get(id)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return newReviewGroup(q.a, []*data.Review{review})[0], nil
+}
+
+type productListResolver struct {
+	products []*productResolver
+	metadata data.Metadata
+}
+
+func (l *productListResolver) Products() []*productResolver {
+	return l.products
+}
+
+func (l *productListResolver) Metadata() *metadataResolver {
+	return &metadataResolver{l.metadata}
+}
+
+type reviewListResolver struct {
+	reviews  []*reviewResolver
+	metadata data.Metadata
+}
+
+func (l *reviewListResolver) Reviews() []*reviewResolver {
+	return l.reviews
+}
+
+func (l *reviewListResolver) Metadata() *metadataResolver {
+	return &metadataResolver{l.metadata}
+}
+
+type metadataResolver struct {
+	m data.Metadata
+}
+
+func (m *metadataResolver) CurrentPage() int32  { return int32(m.m.CurrentPage) }
+func (m *metadataResolver) PageSize() int32     { return int32(m.m.PageSize) }
+func (m *metadataResolver) FirstPage() int32    { return int32(m.m.FirstPage) }
+func (m *metadataResolver) LastPage() int32     { return int32(m.m.LastPage) }
+func (m *metadataResolver) TotalRecords() int32 { return int32(m.m.TotalRecords) }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// products and reviews resolved together (a page of them, the reviews of a page of products...)
+// share a group, the first time a related field is asked on any of them it's loaded for the whole
+// group in one query, so a page of 10 products with their reviews and rating summaries is 3
+// queries instead of 21
+// it's a dataloader that batches by the list the records came from rather than by a time window,
+// the resolvers of a list run in parallel so the loads are guarded
+
+// a load that runs once, everyone asking for it waits for the same result
+type groupLoad[T any] struct {
+	once  sync.Once
+	value T
+	err   error
+}
+
+func (l *groupLoad[T]) get(load func() (T, error)) (T, error) {
+	l.once.Do(func() {
+		l.value, l.err = load()
+	})
+	return l.value, l.err
+}
+
+// the related data of a group of products
+type productGroup struct {
+	a         *applicationDependencies
+	ids       []int64
+	summaries groupLoad[map[int64]*data.RatingSummary]
+
+	mu      sync.Mutex
+	reviews map[string]*groupLoad[productReviews] // by the arguments of the reviews field
+}
+
+// the reviews of every product of a group, they're a group of their own
+type productReviews struct {
+	byProduct map[int64][]*reviewResolver
+}
+
+func newProductGroup(a *applicationDependencies, products []*data.Product) []*productResolver {
+	group := &productGroup{a: a, reviews: make(map[string]*groupLoad[productReviews])}
+	resolvers := make([]*productResolver, len(products))
+	for i, product := range products {
+		group.ids = append(group.ids, product.PID)
+		resolvers[i] = &productResolver{product, group}
+	}
+	return resolvers
+}
+
+func (g *productGroup) ratingSummaries() (map[int64]*data.RatingSummary, error) {
+	return g.summaries.get(func() (map[int64]*data.RatingSummary, error) {
+		return g.a.reviewModel.RatingSummaries(g.ids)
+	})
+}
+
+func (g *productGroup) topReviews(limit int, filters data.Filters) (productReviews, error) {
+	key := fmt.Sprintf("%d/%s", limit, filters.Sort)
+	g.mu.Lock()
+	load, ok := g.reviews[key]
+	if !ok {
+		load = &groupLoad[productReviews]{}
+		g.reviews[key] = load
+	}
+	g.mu.Unlock()
+
+	return load.get(func() (productReviews, error) {
+		top, err := g.a.reviewModel.Top(g.ids, limit, filters)
+		if err != nil {
+			return productReviews{}, err
+		}
+		// all the reviews of the group form one group so their products are loaded together too
+		var all []*data.Review
+		for _, id := range g.ids {
+			all = append(all, top[id]...)
+		}
+		resolvers := newReviewGroup(g.a, all)
+		loaded := productReviews{byProduct: make(map[int64][]*reviewResolver)}
+		for _, resolver := range resolvers {
+			loaded.byProduct[resolver.r.Prod_ID] = append(loaded.byProduct[resolver.r.Prod_ID], resolver)
+		}
+		return loaded, nil
+	})
+}
+
+// the related data of a group of reviews
+type reviewGroup struct {
+	a        *applicationDependencies
+	ids      []int64 // of the products
+	products groupLoad[map[int64]*productResolver]
+}
+
+func newReviewGroup(a *applicationDependencies, reviews []*data.Review) []*reviewResolver {
+	group := &reviewGroup{a: a}
+	resolvers := make([]*reviewResolver, len(reviews))
+	seen := make(map[int64]bool)
+	for i, review := range reviews {
+		if !seen[review.Prod_ID] {
+			seen[review.Prod_ID] = true
+			group.ids = append(group.ids, review.Prod_ID)
+		}
+		resolvers[i] = &reviewResolver{review, group}
+	}
+	return resolvers
+}
+
+func (g *reviewGroup) product(id int64) (*productResolver, error) {
+	products, err := g.products.get(func() (map[int64]*productResolver, error) {
+		products, err := g.a.productModel.GetMany(g.ids)
+		if err != nil {
+			return nil, err
+		}
+		// in the order of the ids so the group is the same from one run to the next
+		var found []*data.Product
+		for _, id := range g.ids {
+			if product, ok := products[id]; ok {
+				found = append(found, product)
+			}
+		}
+		resolvers := make(map[int64]*productResolver, len(found))
+		for _, resolver := range newProductGroup(g.a, found) {
+			resolvers[resolver.p.PID] = resolver
+		}
+		return resolvers, nil
+	})
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return products[id], nil
+}
+
+type productResolver struct {
+	p     *data.Product
+	group *productGroup
+}
+
+func (r *productResolver) PID() graphql.ID         { return graphql.ID(fmt.Sprint(r.p.PID)) }
+func (r *productResolver) Pname() string           { return r.p.Pname }
+func (r *productResolver) ProductCategory() string { return r.p.Product_Category }
+func (r *productResolver) ImageURL() string        { return r.p.Image_URL }
+func (r *productResolver) AvgRating() float64      { return float64(r.p.Avg_Rating) }
+func (r *productResolver) Version() int32          { return r.p.Version }
+
+func (r *productResolver) RatingSummary() (*ratingSummaryResolver, error) {
+	summaries, err := r.group.ratingSummaries()
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	return &ratingSummaryResolver{summaries[r.p.PID]}, nil
+}
+
+func (r *productResolver) Reviews(args struct {
+	Limit int32
+	Sort  string
+}) ([]*reviewResolver, error) {
+	limit := int(args.Limit)
+	filters := data.Filters{
+		Sort: args.Sort,
+		SortSafeList: []string{"created_at", "helpful_count", "rating",
+			"-created_at", "-helpful_count", "-rating"},
+	}
+	v := validator.New()
+	v.Check(limit >= 1 && limit <= 20, "limit", "must be between 1 and 20")
+	v.Check(validator.PermittedValue(filters.Sort, filters.SortSafeList...), "sort", "invalid sort value")
+	if !v.IsEmpty() {
+		return nil, graphqlValidationError(v)
+	}
+
+	reviews, err := r.group.topReviews(limit, filters)
+	if err != nil {
+		return nil, graphqlFailure(err)
+	}
+	if reviews.byProduct[r.p.PID] == nil {
+		return []*reviewResolver{}, nil
+	}
+	return reviews.byProduct[r.p.PID], nil
+}
+
+type ratingSummaryResolver struct {
+	s *data.RatingSummary
+}
+
+func (r *ratingSummaryResolver) Count() int32     { return int32(r.s.Count) }
+func (r *ratingSummaryResolver) Average() float64 { return r.s.Average }
+
+func (r *ratingSummaryResolver) Distribution() []*ratingCountResolver {
+	counts := make([]*ratingCountResolver, 0, len(r.s.Distribution))
+	for rating, count := range r.s.Distribution {
+		counts = append(counts, &ratingCountResolver{int32(rating), int32(count)})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].rating < counts[j].rating })
+	return counts
+}
+
+type ratingCountResolver struct {
+	rating int32
+	count  int32
+}
+
+func (r *ratingCountResolver) Rating() int32 { return r.rating }
+func (r *ratingCountResolver) Count() int32  { return r.count }
+
+type reviewResolver struct {
+	r     *data.Review
+	group *reviewGroup
+}
+
+func (r *reviewResolver) RID() graphql.ID          { return graphql.ID(fmt.Sprint(r.r.RID)) }
+func (r *reviewResolver) ProdID() graphql.ID       { return graphql.ID(fmt.Sprint(r.r.Prod_ID)) }
+func (r *reviewResolver) Rating() int32            { return int32(r.r.Rating) }
+func (r *reviewResolver) HelpfulCount() int32      { return int32(r.r.Helpful_Count) }
+func (r *reviewResolver) Body() string             { return r.r.Body }
+func (r *reviewResolver) Pros() []string           { return nonNilList(r.r.Pros) }
+func (r *reviewResolver) Cons() []string           { return nonNilList(r.r.Cons) }
+func (r *reviewResolver) Author() string           { return r.r.Author }
+func (r *reviewResolver) Variant() string          { return r.r.Variant }
+func (r *reviewResolver) VerifiedPurchase() bool   { return r.r.Verified }
+func (r *reviewResolver) Edited() bool             { return r.r.Edited }
+func (r *reviewResolver) Status() string           { return r.r.Status }
+func (r *reviewResolver) SentimentScore() *float64 { return r.r.Score }
+func (r *reviewResolver) SentimentMismatch() bool  { return r.r.Mismatch }
+func (r *reviewResolver) Version() int32           { return r.r.Version }
+
+func (r *reviewResolver) EditedAt() *string {
+	if r.r.EditedAt == nil {
+		return nil
+	}
+	editedAt := r.r.EditedAt.Format(time.RFC3339)
+	return &editedAt
+}
+
+func (r *reviewResolver) Sentiment() *string {
+	return optionalString(r.r.Sentiment)
+}
+
+func (r *reviewResolver) Language() *string {
+	return optionalString(r.r.Language)
+}
+
+func (r *reviewResolver) Product() (*productResolver, error) {
+	return r.group.product(r.r.Prod_ID)
+}
+
+// empty is null, like the omitempty fields of the JSON API
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
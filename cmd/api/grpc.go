@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/reviewspb"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the ProductService and ReviewService of proto/reviews/v1/reviews.proto for our internal services,
+// served on -grpc-port next to the JSON API with the same models and validation
+// the errors are the status codes of the problems the JSON API would send (see grpcFailure)
+
+func (a *applicationDependencies) serveGRPC() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", a.config.grpc.port))
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(a.recoverGRPCPanic))
+	reviewspb.RegisterProductServiceServer(server, &productService{a: a})
+	reviewspb.RegisterReviewServiceServer(server, &reviewService{a: a})
+
+	a.logger.Info("starting gRPC server", "address", listener.Addr().String())
+	return server.Serve(listener)
+}
+
+// the gRPC version of recoverPanic, a panic in a method is an Internal error instead of a crash
+func (a *applicationDependencies) recoverGRPCPanic(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = a.grpcFailure(info.FullMethod, fmt.Errorf("%s", recovered))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// InvalidArgument with the fields in a BadRequest detail, like the errors of validation_failed
+func grpcValidationError(v *validator.Validator) error {
+	fields := make([]string, 0, len(v.Errors))
+	for field := range v.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations,
+			&errdetails.BadRequest_FieldViolation{Field: field, Description: v.Errors[field]})
+	}
+	st, err := status.New(codes.InvalidArgument, "the request contains invalid fields").WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "the request contains invalid fields")
+	}
+	return st.Err()
+}
+
+// the gRPC version of the error responses in errors.go
+func (a *applicationDependencies) grpcFailure(method string, err error) error {
+	var duplicateError *data.DuplicateReviewError
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "the requested resource could not be found")
+	case errors.Is(err, data.ErrEditConflict):
+		return status.Error(codes.Aborted, "unable to update the record due to an edit conflict, please try again")
+	case errors.As(err, &duplicateError):
+		return status.Errorf(codes.AlreadyExists, "the review is a duplicate of review %d", duplicateError.DuplicateOf)
+	default:
+		// the client only sees Internal, what went wrong goes to the log
+		a.logger.Error(err.Error(), "method", method)
+		return status.Error(codes.Internal, "the server encountered a problem and could not proccess your request")
+	}
+}
+
+func grpcID(id int64, key string, v *validator.Validator) {
+	v.Check(id > 0, key, "must be a valid id")
+}
+
+// 0 and "" are the defaults of the list requests
+func grpcFilters(page, pageSize int32, sort, defaultSort string, safeList []string) data.Filters {
+	filters := data.Filters{Page: 1, PageSize: 10, Sort: defaultSort, SortSafeList: safeList}
+	if page != 0 {
+		filters.Page = int(page)
+	}
+	if pageSize != 0 {
+		filters.PageSize = int(pageSize)
+	}
+	if sort != "" {
+		filters.Sort = sort
+	}
+	return filters
+}
+
+func productMessage(product *data.Product) *reviewspb.Product {
+	return &reviewspb.Product{
+		Pid:             product.PID,
+		Pname:           product.Pname,
+		ProductCategory: product.Product_Category,
+		ImageUrl:        product.Image_URL,
+		AvgRating:       product.Avg_Rating,
+		Version:         product.Version,
+	}
+}
+
+func reviewMessage(review *data.Review) *reviewspb.Review {
+	message := &reviewspb.Review{
+		Rid:               review.RID,
+		ProdId:            review.Prod_ID,
+		Rating:            int32(review.Rating),
+		HelpfulCount:      int32(review.Helpful_Count),
+		Body:              review.Body,
+		Pros:              review.Pros,
+		Cons:              review.Cons,
+		Author:            review.Author,
+		Variant:           review.Variant,
+		VerifiedPurchase:  review.Verified,
+		Edited:            review.Edited,
+		Status:            review.Status,
+		Sentiment:         review.Sentiment,
+		SentimentScore:    review.Score,
+		SentimentMismatch: review.Mismatch,
+		Language:          review.Language,
+		Version:           review.Version,
+		ProductName:       review.ProductName,
+	}
+	if review.EditedAt != nil {
+		message.EditedAt = timestamppb.New(*review.EditedAt)
+	}
+	return message
+}
+
+func metadataMessage(metadata data.Metadata) *reviewspb.Metadata {
+	return &reviewspb.Metadata{
+		CurrentPage:  int32(metadata.CurrentPage),
+		PageSize:     int32(metadata.PageSize),
+		FirstPage:    int32(metadata.FirstPage),
+		LastPage:     int32(metadata.LastPage),
+		TotalRecords: int32(metadata.TotalRecords),
+	}
+}
+
+type productService struct {
+	reviewspb.UnimplementedProductServiceServer
+	a *applicationDependencies
+}
+
+func (s *productService) CreateProduct(ctx context.Context, req *reviewspb.CreateProductRequest) (*reviewspb.Product, error) {
+	product := &data.Product{
+		Pname:            req.GetPname(),
+		Product_Category: req.GetProductCategory(),
+		Image_URL:        req.GetImageUrl(),
+	}
+	v := validator.New()
+	data.ValidateProduct(v, product)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err := s.a.productModel.Insert(product)
+	if err != nil {
+		return nil, s.a.grpcFailure("CreateProduct", err)
+	}
+	return productMessage(product), nil
+}
+
+func (s *productService) GetProduct(ctx context.Context, req *reviewspb.GetProductRequest) (*reviewspb.Product, error) {
+	v := validator.New()
+	grpcID(req.GetPid(), "pid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	product, err := s.a.productModel.Get(req.GetPid())
+	if err != nil {
+		return nil, s.a.grpcFailure("GetProduct", err)
+	}
+	return productMessage(product), nil
+}
+
+func (s *productService) UpdateProduct(ctx context.Context, req *reviewspb.UpdateProductRequest) (*reviewspb.Product, error) {
+	v := validator.New()
+	grpcID(req.GetPid(), "pid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	product, err := s.a.productModel.Get(req.GetPid())
+	if err != nil {
+		return nil, s.a.grpcFailure("UpdateProduct", err)
+	}
+
+	if !versionMatches(req.Version, product.Version) {
+		return nil, s.a.grpcFailure("UpdateProduct", data.ErrEditConflict)
+	}
+
+	if req.Pname != nil {
+		product.Pname = req.GetPname()
+	}
+	if req.ProductCategory != nil {
+		product.Product_Category = req.GetProductCategory()
+	}
+	if req.ImageUrl != nil {
+		product.Image_URL = req.GetImageUrl()
+	}
+
+	data.ValidateProduct(v, product)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err = s.a.productModel.Update(product)
+	if err != nil {
+		return nil, s.a.grpcFailure("UpdateProduct", err)
+	}
+	return productMessage(product), nil
+}
+
+func (s *productService) DeleteProduct(ctx context.Context, req *reviewspb.DeleteProductRequest) (*reviewspb.DeleteProductResponse, error) {
+	v := validator.New()
+	grpcID(req.GetPid(), "pid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err := s.a.productModel.Delete(req.GetPid())
+	if err != nil {
+		return nil, s.a.grpcFailure("DeleteProduct", err)
+	}
+	return &reviewspb.DeleteProductResponse{}, nil
+}
+
+func (s *productService) ListProducts(ctx context.Context, req *reviewspb.ListProductsRequest) (*reviewspb.ListProductsResponse, error) {
+	filters := grpcFilters(req.GetPage(), req.GetPageSize(), req.GetSort(), "pid", data.ProductSortSafeList)
+	v := validator.New()
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	products, metadata, err := s.a.productModel.GetAll(req.GetPname(), req.GetProductCategory(),
+		req.GetAvgRating(), nil, filters)
+	if err != nil {
+		return nil, s.a.grpcFailure("ListProducts", err)
+	}
+
+	response := &reviewspb.ListProductsResponse{Metadata: metadataMessage(metadata)}
+	for _, product := range products {
+		response.Products = append(response.Products, productMessage(product))
+	}
+	return response, nil
+}
+
+type reviewService struct {
+	reviewspb.UnimplementedReviewServiceServer
+	a *applicationDependencies
+}
+
+func (s *reviewService) CreateReview(ctx context.Context, req *reviewspb.CreateReviewRequest) (*reviewspb.Review, error) {
+	v := validator.New()
+	review := &data.Review{
+		Prod_ID:      req.GetProdId(),
+		Rating:       reviewRating(req.GetRating(), v),
+		Body:         req.GetBody(),
+		Pros:         req.GetPros(),
+		Cons:         req.GetCons(),
+		Author:       req.GetAuthor(),
+		Variant:      req.GetVariant(),
+		Review_Token: req.GetReviewToken(),
+	}
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	data.ValidateReview(v, review, s.a.reviewModel)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err := s.a.reviewModel.Insert(review)
+	if err != nil {
+		return nil, s.a.grpcFailure("CreateReview", err)
+	}
+	return reviewMessage(review), nil
+}
+
+func (s *reviewService) GetReview(ctx context.Context, req *reviewspb.GetReviewRequest) (*reviewspb.Review, error) {
+	v := validator.New()
+	grpcID(req.GetRid(), "rid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	review, err := s.a.reviewModel.Get(req.GetRid())
+	if err != nil {
+		return nil, s.a.grpcFailure("GetReview", err)
+	}
+	return reviewMessage(review), nil
+}
+
+func (s *reviewService) UpdateReview(ctx context.Context, req *reviewspb.UpdateReviewRequest) (*reviewspb.Review, error) {
+	v := validator.New()
+	grpcID(req.GetRid(), "rid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	review, err := s.a.reviewModel.GetAny(req.GetRid())
+	if err != nil {
+		return nil, s.a.grpcFailure("UpdateReview", err)
+	}
+
+	if !versionMatches(req.Version, review.Version) {
+		return nil, s.a.grpcFailure("UpdateReview", data.ErrEditConflict)
+	}
+
+	if req.Rating != nil {
+		review.Rating = reviewRating(req.GetRating(), v)
+	}
+	if req.Body != nil {
+		review.Body = req.GetBody()
+	}
+	if req.Pros != nil {
+		review.Pros = req.GetPros().GetValues()
+	}
+	if req.Cons != nil {
+		review.Cons = req.GetCons().GetValues()
+	}
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	data.ValidateReview(v, review, s.a.reviewModel)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err = s.a.reviewModel.Update(review)
+	if err != nil {
+		return nil, s.a.grpcFailure("UpdateReview", err)
+	}
+	return reviewMessage(review), nil
+}
+
+func (s *reviewService) DeleteReview(ctx context.Context, req *reviewspb.DeleteReviewRequest) (*reviewspb.DeleteReviewResponse, error) {
+	v := validator.New()
+	grpcID(req.GetRid(), "rid", v)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err := s.a.reviewModel.Delete(req.GetRid())
+	if err != nil {
+		return nil, s.a.grpcFailure("DeleteReview", err)
+	}
+	return &reviewspb.DeleteReviewResponse{}, nil
+}
+
+func (s *reviewService) ListReviews(ctx context.Context, req *reviewspb.ListReviewsRequest) (*reviewspb.ListReviewsResponse, error) {
+	v := validator.New()
+	query := data.ReviewQuery{
+		Prod_ID:       int(req.GetProdId()),
+		Rating:        int(req.GetRating()),
+		Helpful_Count: int(req.GetHelpfulCount()),
+		Verified:      req.GetVerified(),
+		Sentiment:     req.GetSentiment(),
+		Search:        req.GetQ(),
+		Language:      req.GetLang(),
+	}
+	data.ValidateReviewQuery(v, query)
+
+	filters := grpcFilters(req.GetPage(), req.GetPageSize(), req.GetSort(), "rid", data.ReviewSortSafeList)
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	reviews, metadata, err := s.a.reviewModel.GetAll(query, filters)
+	if err != nil {
+		return nil, s.a.grpcFailure("ListReviews", err)
+	}
+
+	response := &reviewspb.ListReviewsResponse{Metadata: metadataMessage(metadata)}
+	for _, review := range reviews {
+		response.Reviews = append(response.Reviews, reviewMessage(review))
+	}
+	return response, nil
+}
+
+func (s *reviewService) VoteReview(ctx context.Context, req *reviewspb.VoteReviewRequest) (*reviewspb.Review, error) {
+	v := validator.New()
+	grpcID(req.GetRid(), "rid", v)
+	v.Check(req.GetIncrement() == 1 || req.GetIncrement() == -1, "increment", "must be either 1 or -1")
+	if !v.IsEmpty() {
+		return nil, grpcValidationError(v)
+	}
+
+	err := s.a.reviewModel.UpdateHelpfulCount(req.GetRid(), int8(req.GetIncrement()))
+	if err != nil {
+		return nil, s.a.grpcFailure("VoteReview", err)
+	}
+
+	// the review with its new count
+	review, err := s.a.reviewModel.Get(req.GetRid())
+	if err != nil {
+		return nil, s.a.grpcFailure("VoteReview", err)
+	}
+	return reviewMessage(review), nil
+}
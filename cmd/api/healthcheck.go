@@ -15,7 +15,7 @@ func (a *applicationDependencies) healthCheckHandler(w http.ResponseWriter,
 		},
 	}
 
-	err := a.writeJson(w, http.StatusOK, data, nil)
+	err := a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
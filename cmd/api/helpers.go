@@ -6,41 +6,55 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
-
-	"github.com/ReynerioSamos/reviews/internal/validator"
-	"github.com/julienschmidt/httprouter"
 )
 
 // This Method will accept:
 // response write (w)
+// the request, the format is the one negotiate picked from its Accept header (JSON by default)
 // status code to send (default is 200)
-// actual data to encode in Json
+// actual data to encode
 // a map of the headers to set for the response
 
 // create and envelope type
 type envelope map[string]any
 
-func (a *applicationDependencies) writeJson(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	jsResponse, err := json.MarshalIndent(data, "", "\t")
+func (a *applicationDependencies) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// a 304 is only the headers (ETag, Last-Modified...), it must not have a body
+	if status == http.StatusNotModified {
+		setResponseHeaders(w, headers)
+		w.WriteHeader(status)
+		return nil
+	}
+
+	rend, response, err := renderBody(r, data)
 	if err != nil {
+		// only CSV was asked for and this isn't a table, the headers are left out since they
+		// were about the data
+		if errors.Is(err, errNotTabular) {
+			a.notAcceptableResponse(w, r)
+			return nil
+		}
 		return err
 	}
-	jsResponse = append(jsResponse, '\n')
-	for key, value := range headers {
-		w.Header()[key] = value
-	}
-	w.Header().Set("Content-Type", "application/json")
+	setResponseHeaders(w, headers)
+	w.Header().Set("Content-Type", rend.contentType)
 	w.WriteHeader(status)
-	_, err = w.Write(jsResponse)
+	_, err = w.Write(response)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func setResponseHeaders(w http.ResponseWriter, headers http.Header) {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Add("Vary", "Accept")
+}
+
 func (a *applicationDependencies) readJson(w http.ResponseWriter, r *http.Request, destination any) error {
 	// max size of the request body (250KB seems reasonable)
 	maxBytes := 256_000
@@ -99,46 +113,108 @@ func (a *applicationDependencies) readJson(w http.ResponseWriter, r *http.Reques
 	return nil
 }
 
+// the path and query parameters are parsed and checked against openapi.json by validateRequest,
+// these read the values it found, a parameter that wasn't sent gets the default
+
+// the value of a parameter as validateRequest converted it, nil when it wasn't sent
+func paramValue(r *http.Request, name string) any {
+	params, _ := r.Context().Value(paramsContextKey).(requestParams)
+	return params[name]
+}
+
 func (a *applicationDependencies) readIDParam(r *http.Request) (int64, error) {
-	// get the URL parameters
-	params := httprouter.ParamsFromContext(r.Context())
-	// Convert the id from string into int
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
-	}
-	return id, nil
+	value, ok := paramValue(r, "id").(int64)
+	if !ok {
+		return 0, errors.New("missing id parameter")
+	}
+	return value, nil
+}
+
+// same as readIDParam but for other positive integer URL parameters like :revision
+func (a *applicationDependencies) readIntParam(r *http.Request, name string) (int, error) {
+	value, ok := paramValue(r, name).(int64)
+	if !ok {
+		return 0, fmt.Errorf("missing %s parameter", name)
+	}
+	return int(value), nil
 }
 
-func (a *applicationDependencies) getSingleQueryParameter(queryParameters url.Values, key string, defaultValue string) string {
-	// url.values is a key:value hash map of the query parameter
-	result := queryParameters.Get(key)
-	if result == "" {
+func (a *applicationDependencies) getSingleQueryParameter(r *http.Request, key string, defaultValue string) string {
+	value, ok := paramValue(r, key).(string)
+	if !ok {
 		return defaultValue
 	}
-	return result
+	return value
 }
 
 // call when we have multiple comma-separate values
-func (a *applicationDependencies) getMultipleQueryParameters(queryParameters url.Values, key string, defaultValue []string) []string {
-	result := queryParameters.Get(key)
-	if result == "" {
+func (a *applicationDependencies) getMultipleQueryParameters(r *http.Request, key string, defaultValue []string) []string {
+	values, ok := paramValue(r, key).([]any)
+	if !ok {
 		return defaultValue
 	}
-	return strings.Split(result, ",")
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, fmt.Sprint(value))
+	}
+	return result
 }
 
-// this method can cause a validation error when trying to convert the string to a valid integer value
-func (a *applicationDependencies) getSingleIntegerParameter(queryParameters url.Values, key string, defaultValue int, v *validator.Validator) int {
-	result := queryParameters.Get(key)
-	if result == "" {
+func (a *applicationDependencies) getSingleIntegerParameter(r *http.Request, key string, defaultValue int) int {
+	value, ok := paramValue(r, key).(int64)
+	if !ok {
 		return defaultValue
 	}
-	// try to convert to an integer
-	intValue, err := strconv.Atoi(result)
-	if err != nil {
-		v.AddError(key, "must be an integer value")
+	return int(value)
+}
+
+// same idea as getSingleIntegerParameter but for numbers like ?avg_rating=4.5
+func (a *applicationDependencies) getSingleFloatParameter(r *http.Request, key string, defaultValue float64) float64 {
+	value, ok := paramValue(r, key).(float64)
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+// same idea as getSingleIntegerParameter but for true/false flags like ?verified=true
+func (a *applicationDependencies) getSingleBoolParameter(r *http.Request, key string, defaultValue bool) bool {
+	value, ok := paramValue(r, key).(bool)
+	if !ok {
 		return defaultValue
 	}
-	return intValue
+	return value
+}
+
+// checks the If-Match header against the current version of a product or review
+// no header means the client doesn't care, "*" matches anything that exists and
+// otherwise one of the listed entity tags has to carry the current version, either the ETag
+// we sent ("3-5f1d...") or just the version in quotes ("3")
+// weak tags (W/"3") never match since If-Match needs strong comparison
+func (a *applicationDependencies) ifMatch(r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		tagVersion, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+		parsed, err := strconv.ParseInt(tagVersion, 10, 32)
+		if err == nil && int32(parsed) == version {
+			return true
+		}
+	}
+	return false
+}
+
+// the same check as If-Match for GraphQL and gRPC, they send the version they read (if any)
+// and an old version means someone else changed the record in the meantime
+func versionMatches(expected *int32, version int32) bool {
+	return expected == nil || *expected == version
 }
@@ -1,14 +1,67 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
 )
 
+const requestIDContextKey = contextKey("request_id")
+
+// gives every request an ID, sent back in X-Request-ID and in error responses and logged with errors
+// an X-Request-ID from the client (or a proxy in front of us) is kept if it looks sane
+func (a *applicationDependencies) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// up to 128 letters, digits, dashes and underscores so it's safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c == '-' || c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+func requestIDFor(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
 func (a *applicationDependencies) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			err := recover()
+			// an aborted response (e.g. an export failing half way) just drops the connection
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
 			if err != nil {
 				w.Header().Set("Connection", "close")
 				a.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -17,3 +70,146 @@ func (a *applicationDependencies) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// protects service endpoints with a static bearer token (Authorization: Bearer <token>)
+// if the server wasn't given a token the endpoint stays locked
+func (a *applicationDependencies) requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+		if token == "" || len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// constant time compare so the token can't be guessed from response timings
+		if subtle.ConstantTimeCompare([]byte(headerParts[1]), []byte(token)) != 1 {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// lets clients safely retry a POST by sending the same Idempotency-Key header
+// the first response is stored for the configured TTL and sent back again on a retry
+// requests without the header are handled as usual
+func (a *applicationDependencies) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			a.badRequestResponse(w, r, errors.New("the Idempotency-Key header must not be more than 255 characters"))
+			return
+		}
+
+		// the body is read here to hash it, the handler gets a copy
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 256_000))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			a.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		// the query string is part of the request too (e.g. ?mode on the batch endpoints) and so is
+		// the format negotiate picked, a replay can only send back the format it was stored in
+		format := fmt.Sprintf("%s %t\n", rendererFor(r).name, acceptsJSON(r.Header.Get("Accept")))
+		hash := sha256.Sum256(append([]byte(r.URL.RawQuery+"\n"+format), body...))
+
+		record := &data.IdempotencyRecord{
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestHash: hex.EncodeToString(hash[:]),
+			ExpiresAt:   time.Now().Add(a.config.idempotency.ttl),
+		}
+		existing, err := a.idempotencyModel.Reserve(record)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				a.idempotencyKeyMismatchResponse(w, r)
+			case existing.Status == 0:
+				a.idempotencyKeyInUseResponse(w, r)
+			default:
+				for key, value := range existing.Headers {
+					w.Header()[key] = value
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// give the key back if the handler doesn't get as far as a stored response (errors, panics)
+		completed := false
+		defer func() {
+			if !completed {
+				err := a.idempotencyModel.Release(record)
+				if err != nil {
+					a.logError(r, err)
+				}
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		// server errors aren't kept, the retry should get another go at it
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Status = recorder.status
+		record.Headers = recorder.header
+		// a replay is a new request, it keeps its own X-Request-ID
+		delete(record.Headers, "X-Request-Id")
+		record.Body = recorder.body.Bytes()
+		err = a.idempotencyModel.Complete(record)
+		if err != nil {
+			a.logError(r, err)
+			return
+		}
+		completed = true
+	}
+}
+
+// passes the response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
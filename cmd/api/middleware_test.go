@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+)
+
+func TestIdempotentReplayOfCreatedReview(t *testing.T) {
+	a := newIdempotencyTestApp(t)
+	handler := a.routes()
+
+	send := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/review", strings.NewReader(`{"prod_id": 3, "rating": 4}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "retry-me")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := send("")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for the first request, got %d: %s", first.Code, first.Body)
+	}
+
+	replay := send("application/json")
+	if replay.Code != http.StatusCreated {
+		t.Fatalf("expected the replay to be a 201, got %d: %s", replay.Code, replay.Body)
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("expected the response to be a replay")
+	}
+	if location := replay.Header().Get("Location"); location != "/v1/review/7" {
+		t.Fatalf("expected Location /v1/review/7, got %q", location)
+	}
+	if contentType := replay.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected a JSON replay, got %q", contentType)
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Fatalf("expected the first body back, got %s", replay.Body)
+	}
+	var body struct {
+		Review struct {
+			RID int64 `json:"rid"`
+		} `json:"review"`
+	}
+	err := json.Unmarshal(replay.Body.Bytes(), &body)
+	if err != nil || body.Review.RID != 7 {
+		t.Fatalf("expected the body to be only the review as JSON, got %s", replay.Body)
+	}
+
+	// the stored response is JSON, it can't be the answer to a request for XML
+	other := send("application/xml")
+	if other.Code != http.StatusUnprocessableEntity || !strings.Contains(other.Body.String(), "idempotency_key_mismatch") {
+		t.Fatalf("expected a 422 for a retry in another format, got %d: %s", other.Code, other.Body)
+	}
+}
+
+func newIdempotencyTestApp(t *testing.T) *applicationDependencies {
+	sql.Register("fake-"+t.Name(), &fakeDriver{keys: map[string]*fakeIdempotencyKey{}})
+	db, err := sql.Open("fake-"+t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	a := &applicationDependencies{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		reviewModel:      data.ReviewModel{DB: db},
+		idempotencyModel: data.IdempotencyModel{DB: db},
+	}
+	a.config.idempotency.ttl = time.Hour
+	return a
+}
+
+// just enough of a database for creating a review behind an Idempotency-Key, the keys are kept
+// in memory and everything else gets a canned answer
+type fakeDriver struct {
+	mu   sync.Mutex
+	keys map[string]*fakeIdempotencyKey
+}
+
+type fakeIdempotencyKey struct {
+	hash    string
+	status  any
+	headers []byte
+	body    []byte
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, affected := c.d.answer(query, args)
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _ := c.d.answer(query, args)
+	return &fakeRows{rows: rows}, nil
+}
+
+func (d *fakeDriver) answer(query string, args []driver.NamedValue) ([][]driver.Value, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case strings.Contains(query, "INSERT INTO idempotency_keys"):
+		key := args[0].Value.(string)
+		if d.keys[key] != nil {
+			return nil, 0
+		}
+		d.keys[key] = &fakeIdempotencyKey{hash: args[3].Value.(string), headers: []byte("null")}
+		return nil, 1
+	case strings.Contains(query, "SELECT request_hash"):
+		key := d.keys[args[0].Value.(string)]
+		return [][]driver.Value{{key.hash, key.status, key.headers, key.body, now.Add(time.Hour)}}, 0
+	case strings.Contains(query, "UPDATE idempotency_keys"):
+		key := d.keys[args[0].Value.(string)]
+		key.status, key.headers, key.body = args[3].Value, args[4].Value.([]byte), args[5].Value.([]byte)
+		return nil, 1
+	case strings.Contains(query, "DELETE FROM idempotency_keys"):
+		return nil, 0
+	case strings.Contains(query, "SELECT EXISTS(SELECT 1 FROM product"):
+		return [][]driver.Value{{true}}, 0
+	case strings.Contains(query, "INSERT INTO review"):
+		// reviewColumns
+		return [][]driver.Value{{
+			int64(7), now, int64(3), "Kettle", int64(4), int64(0),
+			"", []byte("{}"), []byte("{}"), "", "", false, nil,
+			data.ReviewPublished, "", nil,
+			"", nil, false, "",
+			int64(1), now,
+		}}, 1
+	case strings.Contains(query, "SELECT COALESCE(avg_rating, 0) FROM product"):
+		return [][]driver.Value{{float64(4)}}, 0
+	}
+	return nil, 1
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+// the columns are only counted by database/sql, the names don't matter
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+)
+
+// lists the reviews that were held for moderation (e.g. flagged as duplicates)
+func (a *applicationDependencies) listPendingReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := data.Filters{
+		Page:     a.getSingleIntegerParameter(r, "page", 1),
+		PageSize: a.getSingleIntegerParameter(r, "page_size", 10),
+		// the queue is always oldest first, sort is only here to satisfy ValidateFilters
+		Sort:         "created_at",
+		SortSafeList: []string{"created_at"},
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := a.reviewModel.GetPending(filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"reviews":   reviews,
+		"@metadata": metadata,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// publishes or rejects a held review
+func (a *applicationDependencies) moderateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Status string `json:"status"`
+	}
+
+	err = a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateModerationStatus(v, incomingData.Status)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review, err := a.reviewModel.SetStatus(id, incomingData.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"review": review,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// review bombing alerts raised by the anomaly detector, ?resolved=true shows the closed ones
+func (a *applicationDependencies) listAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	resolved := a.getSingleBoolParameter(r, "resolved", false)
+	filters := data.Filters{
+		Page:     a.getSingleIntegerParameter(r, "page", 1),
+		PageSize: a.getSingleIntegerParameter(r, "page_size", 10),
+		// alerts are always newest first
+		Sort:         "last_seen_at",
+		SortSafeList: []string{"last_seen_at"},
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	anomalies, metadata, err := a.anomalyModel.GetAll(resolved, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"anomalies": anomalies,
+		"@metadata": metadata,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// closes an alert once a moderator has dealt with the held reviews
+func (a *applicationDependencies) resolveAnomalyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.anomalyModel.Resolve(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "anomaly successfully resolved",
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
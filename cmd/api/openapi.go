@@ -0,0 +1,111 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// the OpenAPI 3.1 document of the API is written by hand in openapi.json and compiled into the
+// binary, routes() refuses to start if a route is missing from it (or it describes one that
+// doesn't exist) so the two can't drift apart
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// the document as it is, not through writeResponse (it's the same in every format)
+func (a *applicationDependencies) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(openAPIDocument)
+}
+
+// a page that reads /v1/openapi.json and lists the endpoints, nothing is loaded from a CDN
+func (a *applicationDependencies) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// an httprouter that remembers what was registered on it so it can be compared with the document
+type routeTable struct {
+	*httprouter.Router
+	registered []registeredRoute
+}
+
+type registeredRoute struct {
+	method string
+	path   string
+}
+
+func (t *routeTable) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	t.registered = append(t.registered, registeredRoute{method, path})
+	t.Router.HandlerFunc(method, path, handler)
+}
+
+// compares the registered routes with the paths of the document, both ways
+// a path of the document can also be served by a wildcard route (POST /v1/review/batch is
+// POST /v1/review/:id), so the document side is checked by looking the path up in the router
+func (t *routeTable) checkSpecCoverage(document []byte) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(document, &spec)
+	if err != nil {
+		return fmt.Errorf("reading openapi.json: %w", err)
+	}
+
+	var problems []string
+	for _, route := range t.registered {
+		operations, ok := spec.Paths[specPath(route.path)]
+		if !ok || operations[strings.ToLower(route.method)] == nil {
+			problems = append(problems, fmt.Sprintf("%s %s is not in openapi.json", route.method, route.path))
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !validator.PermittedValue(method, "get", "put", "post", "delete", "options", "head", "patch", "trace") {
+				continue
+			}
+			handler, _, _ := t.Lookup(strings.ToUpper(method), examplePath(path))
+			if handler == nil {
+				problems = append(problems, fmt.Sprintf("openapi.json describes %s %s which has no route", strings.ToUpper(method), path))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("the routes and openapi.json don't match:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// /v1/review/:id/revisions -> /v1/review/{id}/revisions
+func specPath(routePath string) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// /v1/review/{id}/revisions -> /v1/review/1/revisions, a path the router can look up
+func examplePath(specPath string) string {
+	segments := strings.Split(specPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = "1"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+)
+
+func TestSpecCoversEveryRoute(t *testing.T) {
+	a := &applicationDependencies{}
+	err := a.routeTable().checkSpecCoverage(openAPIDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpecCoverageCatchesUndocumentedRoute(t *testing.T) {
+	a := &applicationDependencies{}
+	router := a.routeTable()
+	router.HandlerFunc(http.MethodGet, "/v1/undocumented", a.healthCheckHandler)
+
+	err := router.checkSpecCoverage(openAPIDocument)
+	if err == nil || !strings.Contains(err.Error(), "GET /v1/undocumented is not in openapi.json") {
+		t.Fatalf("expected the undocumented route to be reported, got %v", err)
+	}
+}
+
+func TestSpecCoverageCatchesMissingRoute(t *testing.T) {
+	a := &applicationDependencies{}
+	document := `{"paths": {"/v1/healthcheck": {"get": {}}, "/v1/missing": {"get": {}}}}`
+
+	err := a.routeTable().checkSpecCoverage([]byte(document))
+	if err == nil || !strings.Contains(err.Error(), "openapi.json describes GET /v1/missing which has no route") {
+		t.Fatalf("expected the missing route to be reported, got %v", err)
+	}
+}
+
+// openapi.json is written by hand, the ?sort= values it lists have to be the ones the models accept
+func TestSpecSortValuesMatchSafeLists(t *testing.T) {
+	spec, err := loadAPISpec(openAPIDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		name     string
+		safeList []string
+	}{
+		{"/v1/product", "sort", data.ProductSortSafeList},
+		{"/v1/product", "fields", data.ProductFieldSafeList},
+		{"/v1/review", "sort", data.ReviewSortSafeList},
+	}
+	for _, tt := range tests {
+		operation, _ := spec.find(http.MethodGet, tt.path)
+		if operation == nil {
+			t.Fatalf("GET %s is not in openapi.json", tt.path)
+		}
+		var enum []any
+		for _, parameter := range operation.Parameters {
+			if parameter.In == "query" && parameter.Name == tt.name {
+				schema := spec.resolve(parameter.Schema)
+				if schema.Items != nil {
+					schema = spec.resolve(schema.Items)
+				}
+				enum = schema.Enum
+			}
+		}
+
+		var listed []string
+		for _, value := range enum {
+			listed = append(listed, fmt.Sprint(value))
+		}
+		if !slices.Equal(sortedCopy(listed), sortedCopy(tt.safeList)) {
+			t.Errorf("GET %s ?%s= lists %v in openapi.json but the safe list is %v", tt.path, tt.name, listed, tt.safeList)
+		}
+	}
+}
+
+// the handlers only see query parameters that validateRequest checked against the spec, one that
+// isn't in openapi.json is silently left at its default, so every name read through the
+// get...Parameter helpers has to be a query parameter of some operation
+func TestSpecHasEveryQueryParameter(t *testing.T) {
+	declared := map[string]bool{}
+	spec, err := loadAPISpec(openAPIDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range spec.routes {
+		for _, operation := range route.operations {
+			for _, parameter := range operation.Parameters {
+				if parameter.In == "query" {
+					declared[parameter.Name] = true
+				}
+			}
+		}
+	}
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(parsed, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !strings.HasPrefix(selector.Sel.Name, "get") || !strings.HasSuffix(strings.TrimSuffix(selector.Sel.Name, "s"), "Parameter") {
+				return true
+			}
+			name, ok := call.Args[1].(*ast.BasicLit)
+			if !ok || name.Kind != token.STRING {
+				return true
+			}
+			value, _ := strconv.Unquote(name.Value)
+			if !declared[value] {
+				t.Errorf("%s reads ?%s= but openapi.json doesn't declare it", fset.Position(call.Pos()), value)
+			}
+			return true
+		})
+	}
+}
+
+func sortedCopy(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/outbox"
+)
+
+// the events of the review changes are written to the outbox with the changes themselves, the
+// relay publishes them to the sinks picked with -outbox-sinks
+
+func (a *applicationDependencies) newOutboxRelay(outboxModel data.OutboxModel) (*outbox.Relay, error) {
+	relay := &outbox.Relay{
+		Model:      outboxModel,
+		Logger:     a.logger,
+		Poll:       a.config.outbox.poll,
+		Timeout:    a.config.outbox.timeout,
+		Backoff:    a.config.outbox.backoff,
+		MaxBackoff: a.config.outbox.maxBackoff,
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(a.config.outbox.sinks, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case "webhooks":
+			relay.Sinks = append(relay.Sinks, outbox.WebhookSink{Model: a.webhookModel})
+		case "stdout":
+			relay.Sinks = append(relay.Sinks, &outbox.StdoutSink{Writer: os.Stdout})
+		case "nats":
+			sink, err := outbox.NewNATSSink(a.config.nats.url, a.config.nats.prefix)
+			if err != nil {
+				return nil, err
+			}
+			relay.Sinks = append(relay.Sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q, must be webhooks, nats or stdout", name)
+		}
+	}
+	return relay, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// PATCH bodies can be a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902), picked with the
+// Content-Type, both are applied to the editable fields of the record and the result is read and
+// validated the same way as a plain JSON update
+// plain application/json keeps working as before (a field that isn't sent isn't changed)
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// sent with a 415 so the client knows what it can use
+const acceptPatch = "application/json, " + mergePatchType + ", " + jsonPatchType
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// the patch is valid but can't be applied to the record (e.g. it removes a path that isn't there)
+type patchApplyError struct {
+	err error
+}
+
+func (e *patchApplyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *patchApplyError) Unwrap() error {
+	return e.err
+}
+
+// reads the body of a PATCH request into destination
+// current is the record's editable fields as they are now, the patch is applied to them
+// patched is true for merge patches and JSON Patches, destination then holds the whole
+// document after the patch and a field missing from it was removed rather than not sent
+func (a *applicationDependencies) readPatch(w http.ResponseWriter, r *http.Request, current any, destination any) (bool, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return false, errUnsupportedMediaType
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		return false, a.readJson(w, r, destination)
+	case mergePatchType, jsonPatchType:
+	default:
+		return false, errUnsupportedMediaType
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 256_000))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return false, fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return false, err
+	}
+	document, err := json.Marshal(current)
+	if err != nil {
+		return false, err
+	}
+
+	var result []byte
+	if mediaType == mergePatchType {
+		if !json.Valid(body) {
+			return false, errors.New("the body contains badly-formed JSON")
+		}
+		result, err = jsonpatch.MergePatch(document, body)
+		if err != nil {
+			return false, fmt.Errorf("invalid merge patch: %w", err)
+		}
+	} else {
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return false, fmt.Errorf("invalid JSON Patch: %w", err)
+		}
+		result, err = patch.Apply(document)
+		if err != nil {
+			return false, &patchApplyError{err}
+		}
+	}
+
+	// the patched document goes through readJson so unknown fields and wrong types
+	// get the same errors as a plain update
+	r.Body = io.NopCloser(bytes.NewReader(result))
+	return true, a.readJson(w, r, destination)
+}
+
+// the response for an error from readPatch
+func (a *applicationDependencies) patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var applyError *patchApplyError
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		a.unsupportedMediaTypeResponse(w, r, acceptPatch)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		a.patchTestFailedResponse(w, r, err)
+	case errors.As(err, &applyError):
+		a.patchNotApplicableResponse(w, r, err)
+	default:
+		a.badRequestResponse(w, r, err)
+	}
+}
+
+// pros and cons can be nil on a record, in a patch document they're an empty list
+func nonNilList(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/ReynerioSamos/reviews/internal/data"
 	"github.com/ReynerioSamos/reviews/internal/validator"
@@ -51,9 +51,6 @@ func (a *applicationDependencies) createProductHandler(w http.ResponseWriter, r
 		return
 	}
 
-	// for now display the result
-	fmt.Fprintf(w, "%+v\n", incomingData)
-
 	// Set a Location header. The path to the newly created product
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/product/%d", product.PID))
@@ -62,7 +59,7 @@ func (a *applicationDependencies) createProductHandler(w http.ResponseWriter, r
 	data := envelope{
 		"product": product,
 	}
-	err = a.writeJson(w, http.StatusCreated, data, headers)
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -77,8 +74,12 @@ func (a *applicationDependencies) displayProductHandler(w http.ResponseWriter, r
 		return
 	}
 
-	//Call Get() to retrieve the product with the specified id
-	product, err := a.productModel.Get(id)
+	// ?fields= and ?include= (see fields.go)
+	fields := a.getListParameter(r, "fields")
+	includes := a.getListParameter(r, "include")
+
+	//Call GetFields() to retrieve the product with the specified id
+	product, err := a.productModel.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -88,11 +89,28 @@ func (a *applicationDependencies) displayProductHandler(w http.ResponseWriter, r
 		}
 		return
 	}
+	items, tags, err := a.productRepresentations([]*data.Product{product}, fields, includes)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// nothing to send if the client's copy is still current
+	// the embedded reviews can change without the product's updated_at so there's no Last-Modified with them
+	lastModified := product.UpdatedAt
+	if len(includes) > 0 {
+		lastModified = time.Time{}
+	}
+	headers := cacheHeaders(r, nil, tags[0], lastModified)
+	if a.notModified(w, r, headers) {
+		return
+	}
+
 	// display the product
 	data := envelope{
-		"product": product,
+		"product": items[0],
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -119,6 +137,12 @@ func (a *applicationDependencies) updateProductHandler(w http.ResponseWriter, r
 		return
 	}
 
+	// the client may send back the version it read so it doesn't overwrite someone else's changes
+	if !a.ifMatch(r, product.Version) {
+		a.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// Use our temporary incomingData struct to hold the data
 	// Note: types have been changed to pointers to differentiate b/w the client
 	// leaving a field empty intentionally and the field not needing to be updated
@@ -128,13 +152,23 @@ func (a *applicationDependencies) updateProductHandler(w http.ResponseWriter, r
 		Image_URL        *string `json:"image_url"`
 	}
 
-	// decoding
-	err = a.readJson(w, r, &incomingData)
+	// decoding, the body can also be a merge patch or a JSON Patch of the editable fields
+	current := map[string]any{
+		"pname":            product.Pname,
+		"product_category": product.Product_Category,
+		"image_url":        product.Image_URL,
+	}
+	patched, err := a.readPatch(w, r, current, &incomingData)
 	if err != nil {
-		a.badRequestResponse(w, r, err)
+		a.patchErrorResponse(w, r, err)
 		return
 	}
 
+	// a patch gives back the whole document so a field that's missing was removed (or set to null)
+	if patched {
+		product.Pname, product.Product_Category, product.Image_URL = "", "", ""
+	}
+
 	// We need to now check the fields to see which ones need updating
 	// if incomingData.Pname is nil, no update done
 	if incomingData.Pname != nil {
@@ -162,13 +196,20 @@ func (a *applicationDependencies) updateProductHandler(w http.ResponseWriter, r
 	// perform the update
 	err = a.productModel.Update(product)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	// the new ETag can go straight into If-Match for the next update
+	headers := cacheHeaders(r, nil, productETag(product), product.UpdatedAt)
 	data := envelope{
 		"product": product,
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -197,7 +238,7 @@ func (a *applicationDependencies) deleteProductHandler(w http.ResponseWriter, r
 	data := envelope{
 		"message": "product successfully deleted",
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -216,47 +257,26 @@ func (a *applicationDependencies) ListProductsHandler(w http.ResponseWriter, r *
 		data.Filters
 	}
 	// get the query parameters from the URL
-	queryParameters := r.URL.Query()
-
-	// load the query parameters into our struct
-	queryParametersData.Pname = a.getSingleQueryParameter(
-		queryParameters,
-		"pname", "")
-
-	queryParametersData.Product_Category = a.getSingleQueryParameter(
-		queryParameters,
-		"product_category", "")
-
-	// Handle avg_rating as float32
-	avgRatingStr := a.getSingleQueryParameter(
-		queryParameters,
-		"avg_rating", "")
-
-	if avgRatingStr != "" {
-		avgRating, err := strconv.ParseFloat(avgRatingStr, 32)
-		if err != nil {
-			v := validator.New()
-			v.AddError("avg_rating", "must be a valid number")
-			a.failedValidationResponse(w, r, v.Errors)
-			return
-		}
-		queryParametersData.Avg_Rating = float32(avgRating)
-	}
-
 	// create a new validator instance
 	v := validator.New()
 
+	// load the query parameters into our struct
+	queryParametersData.Pname, queryParametersData.Product_Category, queryParametersData.Avg_Rating =
+		a.readProductQuery(r)
+
 	queryParametersData.Filters.Page = a.getSingleIntegerParameter(
-		queryParameters, "page", 1, v)
+		r, "page", 1)
 
 	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(
-		queryParameters, "page_size", 10, v)
+		r, "page_size", 10)
 
 	queryParametersData.Filters.Sort = a.getSingleQueryParameter(
-		queryParameters, "sort", "pid")
+		r, "sort", "pid")
+
+	queryParametersData.Filters.SortSafeList = data.ProductSortSafeList
 
-	queryParametersData.Filters.SortSafeList = []string{"pid", "pname", "product_category", "avg_rating",
-		"-pid", "-pname", "-product_category", "-avg_rating"}
+	fields := a.getListParameter(r, "fields")
+	includes := a.getListParameter(r, "include")
 
 	// Check if our filters are valid
 	data.ValidateFilters(v, queryParametersData.Filters)
@@ -266,19 +286,103 @@ func (a *applicationDependencies) ListProductsHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	products, metadata, err := a.productModel.GetAll(queryParametersData.Pname, queryParametersData.Product_Category, queryParametersData.Avg_Rating, queryParametersData.Filters)
+	products, metadata, err := a.productModel.GetAll(queryParametersData.Pname, queryParametersData.Product_Category, queryParametersData.Avg_Rating, fields, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	items, tags, err := a.productRepresentations(products, fields, includes)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
+	headers := cacheHeaders(r, nil, listETag(r, metadata, tags), time.Time{})
+	if a.notModified(w, r, headers) {
+		return
+	}
 
 	data := envelope{
-		"products":  products,
+		"products":  items,
 		"@metadata": metadata,
 	}
 
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
+
+// aggregated pros/cons for the product page e.g. "Customers say: long battery life (42)"
+func (a *applicationDependencies) productHighlightsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// how many pros/cons to send back for each list
+	limit := a.getSingleIntegerParameter(r, "limit", 5)
+
+	// make sure the product exists so we can 404 instead of sending empty lists
+	_, err = a.productModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	highlights, err := a.reviewModel.Highlights(id, limit)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"highlights": highlights,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// brings back a soft deleted product (moderators only)
+func (a *applicationDependencies) restoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := a.productModel.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"product": product,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// reads the product filters shared by the list and export endpoints
+func (a *applicationDependencies) readProductQuery(r *http.Request) (string, string, float32) {
+	pname := a.getSingleQueryParameter(r, "pname", "")
+	productCategory := a.getSingleQueryParameter(r, "product_category", "")
+	avgRating := float32(a.getSingleFloatParameter(r, "avg_rating", 0))
+	return pname, productCategory, avgRating
+}
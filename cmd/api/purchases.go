@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+)
+
+// batch ingestion of storefront orders
+// the storefront sends {"purchases": [...]} and we use them to mark reviews as verified purchases
+func (a *applicationDependencies) ingestPurchasesHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Purchases []*data.Purchase `json:"purchases"`
+	}
+
+	err := a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	// validate the batch as a whole and then every purchase in it
+	v := validator.New()
+	v.Check(len(incomingData.Purchases) > 0, "purchases", "must contain at least one purchase")
+	v.Check(len(incomingData.Purchases) <= data.MaxPurchaseBatch, "purchases",
+		fmt.Sprintf("must not contain more than %d purchases", data.MaxPurchaseBatch))
+	for i, purchase := range incomingData.Purchases {
+		if purchase == nil {
+			v.AddError(fmt.Sprintf("purchases[%d]", i), "must not be null")
+			continue
+		}
+		data.ValidatePurchase(v, purchase, fmt.Sprintf("purchases[%d].", i))
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	inserted, verified, err := a.purchaseModel.InsertBatch(incomingData.Purchases)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// tell the storefront how much of the batch was new
+	data := envelope{
+		"received":         len(incomingData.Purchases),
+		"inserted":         inserted,
+		"reviews_verified": verified,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// response formats picked with the Accept header, JSON stays the default
+// the format is chosen before the handler runs (see negotiate) so a request for a format
+// we can't produce gets a 406 without creating or changing anything
+type renderer struct {
+	name        string // used to keep the ETags of different formats apart, empty for plain JSON
+	contentType string
+	problemType string // content type of error responses (see problemResponse)
+	render      func(data envelope) ([]byte, error)
+}
+
+var (
+	jsonRenderer        = renderer{"", "application/json", "application/problem+json", renderJSON}
+	compactJSONRenderer = renderer{"compact", "application/vnd.reviews.compact+json", "application/problem+json", renderCompactJSON}
+	csvRenderer         = renderer{"csv", "text/csv; charset=utf-8", "application/problem+json", renderCSV}
+	xmlRenderer         = renderer{"xml", "application/xml; charset=utf-8", "application/problem+xml; charset=utf-8", renderXML}
+	msgpackRenderer     = renderer{"msgpack", "application/msgpack", "application/msgpack", renderMsgpack}
+)
+
+// media types (and ranges) in the Accept header we can answer with
+var renderers = map[string]renderer{
+	"*/*":                                  jsonRenderer,
+	"application/*":                        jsonRenderer,
+	"application/json":                     jsonRenderer,
+	"application/vnd.reviews.compact+json": compactJSONRenderer,
+	"text/csv":                             csvRenderer,
+	"application/xml":                      xmlRenderer,
+	"text/xml":                             xmlRenderer,
+	"application/msgpack":                  msgpackRenderer,
+	"application/x-msgpack":                msgpackRenderer,
+	"application/vnd.msgpack":              msgpackRenderer,
+}
+
+// CSV only works for records and lists of records, anything else (like a message) falls back to
+// JSON when the Accept header allows it and is a 406 otherwise
+var errNotTabular = errors.New("data can't be written as a table")
+
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// the media ranges of an Accept header, highest q value first
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			value, found := strings.CutPrefix(strings.TrimSpace(param), "q=")
+			if found {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{strings.ToLower(strings.TrimSpace(mediaType)), q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// picks the renderer for an Accept header, highest q value first
+// false means none of the listed types can be produced
+func negotiateRenderer(accept string) (renderer, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return jsonRenderer, true
+	}
+
+	for _, mr := range parseAccept(accept) {
+		if mr.q <= 0 {
+			continue
+		}
+		if rend, ok := renderers[mr.mediaType]; ok {
+			return rend, true
+		}
+	}
+	return renderer{}, false
+}
+
+// whether plain JSON is one of the types the Accept header allows, at any q value
+func acceptsJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, mr := range parseAccept(accept) {
+		if mr.q > 0 && renderers[mr.mediaType].contentType == jsonRenderer.contentType {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const rendererContextKey = contextKey("renderer")
+
+// the renderer negotiate picked for the request, JSON if it didn't run
+func rendererFor(r *http.Request) renderer {
+	rend, ok := r.Context().Value(rendererContextKey).(renderer)
+	if !ok {
+		return jsonRenderer
+	}
+	return rend
+}
+
+// works out the response format from the Accept header and sends 406 if we can't produce any of them
+// the export endpoints pick their own formats (CSV and JSON Lines), the docs and GraphQL are
+// only sent one way, so they're left alone
+func (a *applicationDependencies) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/export/") || validator.PermittedValue(r.URL.Path, "/v1/openapi.json", "/v1/docs", "/v1/graphql", "/v1/review/stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rend, ok := negotiateRenderer(r.Header.Get("Accept"))
+		if !ok {
+			a.notAcceptableResponse(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), rendererContextKey, rend)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// each format is a different representation so it needs its own ETag, "3-abc" becomes "3-abc-xml"
+func representationETag(r *http.Request, etag string) string {
+	name := rendererFor(r).name
+	if name == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + name + `"`
+}
+
+// renders data in the request's format, or as JSON when the format can't hold it
+// (CSV of something that isn't a table) and the client takes JSON too, the renderer that was
+// actually used is returned
+func renderBody(r *http.Request, data envelope) (renderer, []byte, error) {
+	rend := rendererFor(r)
+	body, err := rend.render(data)
+	if errors.Is(err, errNotTabular) && acceptsJSON(r.Header.Get("Accept")) {
+		rend = jsonRenderer
+		body, err = rend.render(data)
+	}
+	return rend, body, err
+}
+
+func renderJSON(data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// same as renderJSON without the indenting, for clients that don't need to read it
+func renderCompactJSON(data envelope) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// uses the json tags so the field names match the JSON responses
+func renderMsgpack(data envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	err := enc.Encode(map[string]any(data))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// the data goes through JSON first so the XML has the same names and values as the JSON responses
+// objects become elements named after their keys (<entry key="..."> when the key isn't a valid
+// element name, e.g. "@metadata"), arrays become <item> elements
+func renderXML(data envelope) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var value any
+	err = dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+	err = encodeXML(enc, xml.StartElement{Name: xml.Name{Local: "response"}}, value)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func encodeXML(enc *xml.Encoder, start xml.StartElement, value any) error {
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := xml.StartElement{Name: xml.Name{Local: key}}
+			if !validXMLName(key) {
+				child = xml.StartElement{
+					Name: xml.Name{Local: "entry"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+				}
+			}
+			err = encodeXML(enc, child, v[key])
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			err = encodeXML(enc, xml.StartElement{Name: xml.Name{Local: "item"}}, item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(v)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// letters, digits, _ - and . only, starting with a letter or _
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// writes the one record or list of records in the envelope as CSV, the columns are the
+// json names of the fields in struct order, lists (pros/cons) are joined with |
+// records picked with ?fields= are maps, their columns are the keys in alphabetical order
+// keys starting with @ like @metadata aren't part of the table
+func renderCSV(data envelope) ([]byte, error) {
+	var rows reflect.Value
+	found := 0
+	for key, value := range data {
+		if strings.HasPrefix(key, "@") {
+			continue
+		}
+		rows = reflect.ValueOf(value)
+		found++
+	}
+	if found != 1 || !rows.IsValid() {
+		return nil, errNotTabular
+	}
+	if rows.Kind() != reflect.Slice {
+		single := reflect.MakeSlice(reflect.SliceOf(rows.Type()), 1, 1)
+		single.Index(0).Set(rows)
+		rows = single
+	}
+
+	// the type of the records, from the first one when the slice is a []any
+	elemType := rows.Type().Elem()
+	if elemType.Kind() == reflect.Interface && rows.Len() == 0 {
+		return []byte{}, nil // an empty page, there's nothing to take the columns from
+	}
+	if elemType.Kind() == reflect.Interface && rows.Len() > 0 {
+		elemType = rows.Index(0).Elem().Type()
+	}
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+
+	var header []string
+	var column func(row reflect.Value, i int) reflect.Value
+	switch {
+	case elemType.Kind() == reflect.Struct:
+		// the columns and the index of the field each one comes from
+		var fields []int
+		for i := 0; i < elemType.NumField(); i++ {
+			field := elemType.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			header = append(header, name)
+			fields = append(fields, i)
+		}
+		column = func(row reflect.Value, i int) reflect.Value { return row.Field(fields[i]) }
+	case elemType.Kind() == reflect.Map && elemType.Key().Kind() == reflect.String && rows.Len() > 0:
+		first := rows.Index(0)
+		for first.Kind() == reflect.Interface || first.Kind() == reflect.Pointer {
+			first = first.Elem()
+		}
+		for _, key := range first.MapKeys() {
+			header = append(header, key.String())
+		}
+		sort.Strings(header)
+		column = func(row reflect.Value, i int) reflect.Value {
+			return row.MapIndex(reflect.ValueOf(header[i]).Convert(elemType.Key()))
+		}
+	default:
+		return nil, errNotTabular
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for row.Kind() == reflect.Interface || row.Kind() == reflect.Pointer {
+			if row.IsNil() {
+				break
+			}
+			row = row.Elem()
+		}
+		if row.Kind() != elemType.Kind() {
+			continue
+		}
+		record := make([]string, len(header))
+		for j := range header {
+			record[j] = csvValue(column(row, j))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func csvValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	case []string:
+		return strings.Join(value, "|")
+	}
+
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	}
+
+	// anything else (nested structs, maps) is written as JSON
+	js, err := json.Marshal(v.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(js)
+}
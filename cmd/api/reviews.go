@@ -4,18 +4,27 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/language"
 	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
 // handles Create/Post method
 func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *http.Request) {
 	// hold the incoming data in a struct
 	var incomingData struct {
-		Prod_ID int64 `json:"prod_id"`
-		Rating  int8  `json:"rating"`
+		Prod_ID int64    `json:"prod_id"`
+		Rating  int8     `json:"rating"`
+		Body    string   `json:"body"`
+		Pros    []string `json:"pros"`
+		Cons    []string `json:"cons"`
+		Author  string   `json:"author"`
+		Variant string   `json:"variant"`
+		// from the storefront's order, needed for the verified purchase badge
+		Review_Token string `json:"review_token"`
 	}
 
 	err := a.readJson(w, r, &incomingData)
@@ -26,8 +35,14 @@ func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *
 
 	// stores struct data for incommingdata into Review object
 	review := &data.Review{
-		Prod_ID: incomingData.Prod_ID,
-		Rating:  incomingData.Rating,
+		Prod_ID:      incomingData.Prod_ID,
+		Rating:       incomingData.Rating,
+		Body:         incomingData.Body,
+		Pros:         incomingData.Pros,
+		Cons:         incomingData.Cons,
+		Author:       incomingData.Author,
+		Variant:      incomingData.Variant,
+		Review_Token: incomingData.Review_Token,
 	}
 
 	// validate fields
@@ -40,13 +55,16 @@ func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *
 
 	err = a.reviewModel.Insert(review)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		var duplicateError *data.DuplicateReviewError
+		switch {
+		case errors.As(err, &duplicateError):
+			a.duplicateReviewResponse(w, r, duplicateError)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// print the review data being created
-	fmt.Fprintf(w, "%+v\n", incomingData)
-
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/review/%d", review.RID))
 
@@ -54,14 +72,20 @@ func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJson(w, http.StatusCreated, data, headers)
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
 // Display/Read functionality
+// GET /v1/review/stream shares the route the same way POST /v1/review/batch does (see reviewPostHandler)
 func (a *applicationDependencies) displayReviewHandler(w http.ResponseWriter, r *http.Request) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "stream" {
+		a.streamReviewsHandler(w, r)
+		return
+	}
+
 	id, err := a.readIDParam(r)
 	if err != nil {
 		a.notFoundResponse(w, r)
@@ -78,11 +102,17 @@ func (a *applicationDependencies) displayReviewHandler(w http.ResponseWriter, r
 		}
 		return
 	}
+	// nothing to send if the client's copy is still current
+	headers := cacheHeaders(r, nil, reviewETag(review), review.UpdatedAt)
+	if a.notModified(w, r, headers) {
+		return
+	}
+
 	// return read data as an envelope
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -96,7 +126,7 @@ func (a *applicationDependencies) updateReviewHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	review, err := a.reviewModel.Get(id)
+	review, err := a.reviewModel.GetAny(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -107,21 +137,56 @@ func (a *applicationDependencies) updateReviewHandler(w http.ResponseWriter, r *
 		return
 	}
 
+	// the client may send back the version it read so it doesn't overwrite someone else's changes
+	if !a.ifMatch(r, review.Version) {
+		a.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// temp store data to be updated into a struct
 	var incomingData struct {
-		Rating *int8 `json:"rating"`
+		Rating *int8    `json:"rating"`
+		Body   *string  `json:"body"`
+		Pros   []string `json:"pros"`
+		Cons   []string `json:"cons"`
 	}
 
-	err = a.readJson(w, r, &incomingData)
+	// the body can also be a merge patch or a JSON Patch of the editable fields
+	// the lists are never null in the document so a JSON Patch can add to them ("/pros/-")
+	current := map[string]any{
+		"rating": review.Rating,
+		"body":   review.Body,
+		"pros":   nonNilList(review.Pros),
+		"cons":   nonNilList(review.Cons),
+	}
+	patched, err := a.readPatch(w, r, current, &incomingData)
 	if err != nil {
-		a.badRequestResponse(w, r, err)
+		a.patchErrorResponse(w, r, err)
 		return
 	}
 
+	// a patch gives back the whole document so a field that's missing was removed (or set to null)
+	if patched {
+		review.Rating, review.Body, review.Pros, review.Cons = 0, "", []string{}, []string{}
+	}
+
 	if incomingData.Rating != nil {
 		review.Rating = *incomingData.Rating
 	}
 
+	if incomingData.Body != nil {
+		review.Body = *incomingData.Body
+	}
+
+	// a nil slice means the list wasn't sent, an empty one clears it
+	if incomingData.Pros != nil {
+		review.Pros = incomingData.Pros
+	}
+
+	if incomingData.Cons != nil {
+		review.Cons = incomingData.Cons
+	}
+
 	// validate the incoming data
 	v := validator.New()
 	data.ValidateReview(v, review, a.reviewModel)
@@ -132,15 +197,21 @@ func (a *applicationDependencies) updateReviewHandler(w http.ResponseWriter, r *
 
 	err = a.reviewModel.Update(review)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// return the newly updated data
+	// return the newly updated data, the new ETag can go straight into If-Match for the next update
+	headers := cacheHeaders(r, nil, reviewETag(review), review.UpdatedAt)
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -191,7 +262,7 @@ func (a *applicationDependencies) updateHelpfulCountHandler(w http.ResponseWrite
 	data := envelope{
 		"message": "helpful count updated successfully",
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -219,78 +290,81 @@ func (a *applicationDependencies) deleteReviewHandler(w http.ResponseWriter, r *
 	data := envelope{
 		"message": "review successfully deleted",
 	}
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
-// listReview for listing, searching and sorting
-// currently doesn't work alongside ListProductHandler and I still don't know why,
-// hoping some sleep will let me better see where I went wrong, currently up from 8AM - 4AM
-// working on this Test # 1, only taking breaks for food and bathroom
-func (a *applicationDependencies) ListReviewsHandler(w http.ResponseWriter, r *http.Request) {
-	// store parameters to query data into a struct
-	var queryParametersData struct {
-		Prod_ID       int
-		Rating        int
-		Helpful_Count int
-		data.Filters
+// brings back a soft deleted review (moderators only)
+func (a *applicationDependencies) restoreReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
 	}
-	// get the parameters from the url
-	queryParameters := r.URL.Query()
-
-	prodIDStr := a.getSingleQueryParameter(queryParameters, "prod_id", "")
-	if prodIDStr != "" {
-		prodID, err := strconv.Atoi(prodIDStr)
-		if err != nil {
-			v := validator.New()
-			v.AddError("prod_id", "must be a valid integer")
-			a.failedValidationResponse(w, r, v.Errors)
-			return
+
+	err = a.reviewModel.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
 		}
-		queryParametersData.Prod_ID = prodID
+		return
 	}
 
-	ratingStr := a.getSingleQueryParameter(queryParameters, "rating", "")
-	if ratingStr != "" {
-		rating, err := strconv.Atoi(ratingStr)
-		if err != nil {
-			v := validator.New()
-			v.AddError("rating", "must be a valid integer")
-			a.failedValidationResponse(w, r, v.Errors)
-			return
+	// send the review back if it's visible again, it won't be if its product is still deleted
+	review, err := a.reviewModel.GetAny(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			data := envelope{
+				"message": "review restored, its product is still deleted",
+			}
+			err = a.writeResponse(w, r, http.StatusOK, data, nil)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+			}
+		default:
+			a.serverErrorResponse(w, r, err)
 		}
-		queryParametersData.Rating = rating
+		return
 	}
 
-	helpfulStr := a.getSingleQueryParameter(queryParameters, "helpful_count", "")
-	if helpfulStr != "" {
-		helpful, err := strconv.Atoi(helpfulStr)
-		if err != nil {
-			v := validator.New()
-			v.AddError("helpful_count", "must be a valid integer")
-			a.failedValidationResponse(w, r, v.Errors)
-			return
-		}
-		queryParametersData.Helpful_Count = helpful
+	data := envelope{
+		"review": review,
 	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
 
+// listReview for listing, searching and sorting
+// currently doesn't work alongside ListProductHandler and I still don't know why,
+// hoping some sleep will let me better see where I went wrong, currently up from 8AM - 4AM
+// working on this Test # 1, only taking breaks for food and bathroom
+func (a *applicationDependencies) ListReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// store parameters to query data into a struct
+	var queryParametersData struct {
+		data.ReviewQuery
+		data.Filters
+	}
 	v := validator.New()
+	queryParametersData.ReviewQuery = a.readReviewQuery(r, v)
 
 	queryParametersData.Filters.Page = a.getSingleIntegerParameter(
-		queryParameters, "page", 1, v)
+		r, "page", 1)
 
 	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(
-		queryParameters, "page_size", 10, v)
+		r, "page_size", 10)
 
 	queryParametersData.Filters.Sort = a.getSingleQueryParameter(
-		queryParameters, "sort", "rid")
+		r, "sort", "rid")
 
-	queryParametersData.Filters.SortSafeList = []string{
-		"rid", "rating", "helpful_count", "created_at",
-		"-rid", "-rating", "-helpful_count", "-created_at",
-	}
+	queryParametersData.Filters.SortSafeList = data.ReviewSortSafeList
 
 	data.ValidateFilters(v, queryParametersData.Filters)
 	if !v.IsEmpty() {
@@ -299,22 +373,75 @@ func (a *applicationDependencies) ListReviewsHandler(w http.ResponseWriter, r *h
 	}
 
 	reviews, metadata, err := a.reviewModel.GetAll(
-		queryParametersData.Prod_ID,
-		queryParametersData.Rating,
-		queryParametersData.Helpful_Count,
+		queryParametersData.ReviewQuery,
 		queryParametersData.Filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// the order depends on Accept-Language so caches have to keep them apart
+	headers := make(http.Header)
+	headers.Set("Vary", "Accept-Language")
+
+	// lists only get an ETag, a Last-Modified taken from the newest review on the page
+	// wouldn't change when a review drops off the page
+	tags := make([]string, len(reviews))
+	for i, review := range reviews {
+		tags[i] = reviewETag(review)
+	}
+	headers = cacheHeaders(r, headers, listETag(r, metadata, tags), time.Time{})
+	if a.notModified(w, r, headers) {
+		return
+	}
+
 	data := envelope{
 		"reviews":   reviews,
 		"@metadata": metadata,
 	}
 
-	err = a.writeJson(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
+
+// reads the review filters shared by the list and export endpoints
+func (a *applicationDependencies) readReviewQuery(r *http.Request, v *validator.Validator) data.ReviewQuery {
+	var q data.ReviewQuery
+	q.Prod_ID = a.getSingleIntegerParameter(r, "prod_id", 0)
+	q.Rating = a.getSingleIntegerParameter(r, "rating", 0)
+	q.Helpful_Count = a.getSingleIntegerParameter(r, "helpful_count", 0)
+
+	// only reviews from verified buyers when ?verified=true
+	q.Verified = a.getSingleBoolParameter(
+		r, "verified", false)
+
+	// ?sentiment=negative with ?rating=5 finds 5 star reviews that read as negative
+	q.Sentiment = a.getSingleQueryParameter(
+		r, "sentiment", "")
+
+	// full text search on the body, each review is searched in its own language
+	q.Search = a.getSingleQueryParameter(
+		r, "q", "")
+
+	// ?lang=es only returns reviews written in spanish, without it the languages in the
+	// Accept-Language header are listed first
+	q.Language = a.getSingleQueryParameter(
+		r, "lang", "")
+	if q.Language == "" {
+		q.Preferred = language.Preferred(r.Header.Get("Accept-Language"))
+	}
+	data.ValidateReviewQuery(v, q)
+	return q
+}
+
+// the rating is an int8 in the model and GraphQL and gRPC send an int32,
+// anything out of range would wrap around so it's turned away here
+func reviewRating(rating int32, v *validator.Validator) int8 {
+	if rating < 1 || rating > 5 {
+		v.AddError("rating", "must be between 1 and 5")
+		return 0
+	}
+	return int8(rating)
+}
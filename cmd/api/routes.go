@@ -7,8 +7,29 @@ import (
 )
 
 func (a *applicationDependencies) routes() http.Handler {
-	//set up new router
-	router := httprouter.New()
+	router := a.routeTable()
+
+	// a route without an entry in openapi.json is a bug like a conflicting route, so it
+	// stops the server the same way httprouter does
+	err := router.checkSpecCoverage(openAPIDocument)
+	if err != nil {
+		panic(err)
+	}
+
+	// the document is also what the requests are checked against
+	spec, err := loadAPISpec(openAPIDocument)
+	if err != nil {
+		panic(err)
+	}
+
+	// request ID, panic recover, pick the response format, then check the request
+	return a.requestID(a.recoverPanic(a.negotiate(a.validateRequest(spec, router))))
+}
+
+// every route of the API, without the middleware
+func (a *applicationDependencies) routeTable() *routeTable {
+	//set up new router, it records the routes so they can be checked against openapi.json
+	router := &routeTable{Router: httprouter.New()}
 	// handle 404
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	// handle 405
@@ -18,25 +39,87 @@ func (a *applicationDependencies) routes() http.Handler {
 	//route for health checker
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthCheckHandler)
 
+	// routes for the API description and the docs page built from it
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", a.openAPIHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/docs", a.docsHandler)
+
 	// routes for products CRUD functionality
-	router.HandlerFunc(http.MethodPost, "/v1/product", a.createProductHandler)
+	// the POST routes accept an Idempotency-Key header so clients can retry them safely
+	router.HandlerFunc(http.MethodPost, "/v1/product", a.idempotent(a.createProductHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/product/:id", a.displayProductHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/product/:id", a.updateProductHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/product/:id", a.deleteProductHandler)
 
+	// bulk create/update, POST /v1/review/batch is handled by reviewPostHandler below
+	router.HandlerFunc(http.MethodPost, "/v1/product/batch", a.idempotent(a.batchProductsHandler))
+
+	// route for the aggregated pros/cons of a product
+	router.HandlerFunc(http.MethodGet, "/v1/product/:id/highlights", a.productHighlightsHandler)
+
 	//routes for reviews CRUD functionality
-	router.HandlerFunc(http.MethodPost, "/v1/review", a.createReviewHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/review", a.idempotent(a.createReviewHandler))
+	// GET /v1/review/stream (the SSE stream of new reviews) shares this route, httprouter doesn't
+	// allow a static segment next to :id so displayReviewHandler hands id "stream" to
+	// streamReviewsHandler, the same way POST /v1/review/batch works
 	router.HandlerFunc(http.MethodGet, "/v1/review/:id", a.displayReviewHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/review/:id", a.updateReviewHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/review/:id", a.deleteReviewHandler)
 
-	// route handles helpful_counter handler
-	router.HandlerFunc(http.MethodPost, "/v1/review/:id", a.updateHelpfulCountHandler)
+	// routes to undo a soft delete, moderators only
+	router.HandlerFunc(http.MethodPost, "/v1/admin/product/:id/restore",
+		a.requireToken(a.config.auth.adminToken, a.restoreProductHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/review/:id/restore",
+		a.requireToken(a.config.auth.adminToken, a.restoreReviewHandler))
+
+	// routes for the moderation queue
+	router.HandlerFunc(http.MethodGet, "/v1/admin/moderation",
+		a.requireToken(a.config.auth.adminToken, a.listPendingReviewsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/review/:id/moderation",
+		a.requireToken(a.config.auth.adminToken, a.moderateReviewHandler))
+
+	// routes for the review bombing alerts
+	router.HandlerFunc(http.MethodGet, "/v1/admin/anomalies",
+		a.requireToken(a.config.auth.adminToken, a.listAnomaliesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/anomalies/:id/resolve",
+		a.requireToken(a.config.auth.adminToken, a.resolveAnomalyHandler))
+
+	// routes for the edit history of a review, restoring is for moderators only
+	router.HandlerFunc(http.MethodGet, "/v1/review/:id/revisions", a.listReviewRevisionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/admin/review/:id/revisions/:revision/restore",
+		a.requireToken(a.config.auth.adminToken, a.restoreReviewRevisionHandler))
+
+	// routes for the webhook subscriptions and their delivery logs
+	router.HandlerFunc(http.MethodGet, "/v1/admin/webhooks",
+		a.requireToken(a.config.auth.adminToken, a.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/webhooks",
+		a.requireToken(a.config.auth.adminToken, a.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/webhooks/:id",
+		a.requireToken(a.config.auth.adminToken, a.displayWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/webhooks/:id",
+		a.requireToken(a.config.auth.adminToken, a.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/webhooks/:id",
+		a.requireToken(a.config.auth.adminToken, a.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/webhooks/:id/deliveries",
+		a.requireToken(a.config.auth.adminToken, a.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/webhooks/:id/deliveries/:delivery/redeliver",
+		a.requireToken(a.config.auth.adminToken, a.redeliverWebhookHandler))
+
+	// route for the storefront to push orders (verified purchase badges)
+	router.HandlerFunc(http.MethodPost, "/v1/purchases", a.requireToken(a.config.auth.ingestToken, a.ingestPurchasesHandler))
+
+	// route handles helpful_counter handler (and POST /v1/review/batch)
+	router.HandlerFunc(http.MethodPost, "/v1/review/:id", a.idempotent(a.reviewPostHandler))
+
+	// routes for the full CSV/JSON Lines dumps
+	router.HandlerFunc(http.MethodGet, "/v1/export/products", a.exportProductsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/export/reviews", a.exportReviewsHandler)
+
+	// route for the GraphQL API over products and reviews
+	router.HandlerFunc(http.MethodPost, "/v1/graphql", a.graphqlHandler(a.newGraphQLSchema()))
 
 	// route for ListAll<data> handlers
 	router.HandlerFunc(http.MethodGet, "/v1/product", a.ListProductsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/review", a.ListReviewsHandler)
 
-	//panic recover
-	return a.recoverPanic(router)
+	return router
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/lib/pq"
+)
+
+// GET /v1/review/stream pushes new and updated reviews as Server-Sent Events
+// a trigger on the review table records every change and notifies the review_changes channel,
+// one listener per process reads the changes and hands them to the open streams
+// the change id is the event id, a client reconnecting with Last-Event-ID (EventSource does it
+// by itself) first gets what it missed
+// the changes go out in the order of data.ChangePosition, which isn't always the order of the ids
+
+const (
+	// changes read from the database at a time
+	streamPageSize = 500
+	// changes a stream can fall behind by before it's dropped, the client reconnects and
+	// catches up through Last-Event-ID
+	streamBuffer = 256
+	// a comment is sent this often so proxies don't close a quiet stream
+	streamHeartbeat = 15 * time.Second
+	// how often to look again while changes are held back by an older transaction
+	streamRetry = time.Second
+)
+
+type reviewStream struct {
+	reviewModel data.ReviewModel
+	logger      *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan *data.ReviewChange]bool
+	last        data.ChangePosition // of the last change handed out
+}
+
+func newReviewStream(a *applicationDependencies) *reviewStream {
+	return &reviewStream{
+		reviewModel: a.reviewModel,
+		logger:      a.logger,
+		subscribers: make(map[chan *data.ReviewChange]bool),
+	}
+}
+
+// reads the changes as they're notified, a nil notification means the connection was lost and
+// picked up again, catching up from the last position covers whatever was missed in between
+func (s *reviewStream) run(listener *pq.Listener) {
+	for {
+		last, err := s.reviewModel.LatestPosition()
+		if err == nil {
+			s.last = last
+			break
+		}
+		s.logger.Error(err.Error())
+		time.Sleep(5 * time.Second)
+	}
+
+	// set while changes are held back, the transaction they wait on might never notify
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-listener.Notify:
+			retry = s.catchUp()
+		case <-retry:
+			retry = s.catchUp()
+		case <-time.After(90 * time.Second):
+			// checks the connection is still there, the lost connection shows up as a nil notification
+			go listener.Ping()
+		}
+	}
+}
+
+// hands out the new changes, the channel fires when it's time to look again for held back ones
+func (s *reviewStream) catchUp() <-chan time.Time {
+	for {
+		changes, held, err := s.reviewModel.ChangesSince(s.last, streamPageSize)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return time.After(streamRetry)
+		}
+		for _, change := range changes {
+			s.last = change.Position()
+			if change.Visible {
+				s.broadcast(change)
+			}
+		}
+		if len(changes) < streamPageSize {
+			if held {
+				return time.After(streamRetry)
+			}
+			return nil
+		}
+	}
+}
+
+// a stream that can't keep up is closed rather than holding the others back
+func (s *reviewStream) broadcast(change *data.ReviewChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+func (s *reviewStream) subscribe() chan *data.ReviewChange {
+	subscriber := make(chan *data.ReviewChange, streamBuffer)
+	s.mu.Lock()
+	s.subscribers[subscriber] = true
+	s.mu.Unlock()
+	return subscriber
+}
+
+func (s *reviewStream) unsubscribe(subscriber chan *data.ReviewChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[subscriber] {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// the filters of a stream, zero values match everything
+type streamFilter struct {
+	prodID   int64
+	category string
+}
+
+func (f streamFilter) matches(change *data.ReviewChange) bool {
+	if f.prodID != 0 && change.Review.Prod_ID != f.prodID {
+		return false
+	}
+	return f.category == "" || strings.EqualFold(change.Category, f.category)
+}
+
+func (a *applicationDependencies) streamReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filter := streamFilter{
+		prodID:   int64(a.getSingleIntegerParameter(r, "prod_id", 0)),
+		category: a.getSingleQueryParameter(r, "category", ""),
+	}
+
+	// where to pick up from, nothing is replayed for a new stream
+	var lastEventID int64
+	resume := r.Header.Get("Last-Event-ID") != ""
+	if resume {
+		var err error
+		lastEventID, err = strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		v.Check(err == nil && lastEventID >= 0, "Last-Event-ID", "must be the id of an event")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var last data.ChangePosition
+	if resume {
+		var err error
+		last, err = a.reviewModel.ChangePosition(lastEventID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// the stream stays open, the server's write timeout would cut it off
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// subscribed before the replay so nothing falls in the gap between the two
+	changes := a.reviewStream.subscribe()
+	defer a.reviewStream.unsubscribe(changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if resume {
+		for {
+			// the held back ones come through the subscription once they're ready
+			missed, _, err := a.reviewModel.ChangesSince(last, streamPageSize)
+			if err != nil {
+				// the client reconnects with the last id it got and the replay starts again from there
+				a.logError(r, err)
+				return
+			}
+			for _, change := range missed {
+				last = change.Position()
+				if change.Visible && filter.matches(change) {
+					err = writeReviewEvent(w, change)
+					if err != nil {
+						return
+					}
+				}
+			}
+			if len(missed) < streamPageSize {
+				break
+			}
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				// fell too far behind
+				return
+			}
+			// already sent by the replay
+			if !last.Before(change.Position()) || !filter.matches(change) {
+				continue
+			}
+			err = writeReviewEvent(w, change)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// one event, the data is the review the same way GET /v1/review/:id sends it
+func writeReviewEvent(w http.ResponseWriter, change *data.ReviewChange) error {
+	js, err := json.Marshal(envelope{"review": change.Review})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Type, js)
+	return err
+}
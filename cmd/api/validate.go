@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requests are checked against openapi.json before they reach the handlers: the path parameters,
+// the query parameters and the JSON body must match the schemas of the operation, otherwise the
+// client gets a 422 listing every problem with where it is
+// a path parameter that doesn't match (/v1/product/abc) is a 404 like any other unknown path
+// the checked parameters are handed to the handlers already converted (see the param helpers
+// in helpers.go) so they don't parse them again
+// only the JSON Schema keywords the document uses are supported, the handlers still run their
+// own validation for the rules a schema can't express (a product that exists, a supported language...)
+
+// a JSON Schema, with the keywords openapi.json uses
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Format               string                 `json:"format"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Items                *jsonSchema            `json:"items"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"` // only false is enforced
+	AllOf                []*jsonSchema          `json:"allOf"`
+}
+
+// "type" is a name or a list of names in OpenAPI 3.1
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	var names []string
+	err := json.Unmarshal(data, &names)
+	if err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+type specParameter struct {
+	Ref      string      `json:"$ref"`
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type specOperation struct {
+	Security    []map[string][]string `json:"security"`
+	Parameters  []*specParameter      `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *jsonSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// a path of the document, split in segments so requests can be matched against it
+type specRoute struct {
+	segments   []string
+	static     int // segments that aren't parameters, the route with the most wins
+	operations map[string]*specOperation
+}
+
+// the parts of openapi.json the request validation needs
+type apiSpec struct {
+	schemas map[string]*jsonSchema
+	routes  []*specRoute
+}
+
+// reads the document, the parameters of every operation are resolved and merged with the ones
+// of their path here so it's only done once
+func loadAPISpec(document []byte) (*apiSpec, error) {
+	var raw struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas    map[string]*jsonSchema    `json:"schemas"`
+			Parameters map[string]*specParameter `json:"parameters"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(document, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading openapi.json: %w", err)
+	}
+	spec := &apiSpec{schemas: raw.Components.Schemas}
+
+	resolveParameter := func(parameter *specParameter) (*specParameter, error) {
+		if parameter.Ref == "" {
+			return parameter, nil
+		}
+		resolved, ok := raw.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+		if !ok {
+			return nil, fmt.Errorf("openapi.json: unknown parameter %s", parameter.Ref)
+		}
+		return resolved, nil
+	}
+
+	for path, item := range raw.Paths {
+		route := &specRoute{
+			segments:   strings.Split(path, "/"),
+			operations: make(map[string]*specOperation),
+		}
+		for _, segment := range route.segments {
+			if !strings.HasPrefix(segment, "{") {
+				route.static++
+			}
+		}
+
+		var shared []*specParameter
+		if item["parameters"] != nil {
+			err := json.Unmarshal(item["parameters"], &shared)
+			if err != nil {
+				return nil, fmt.Errorf("openapi.json %s: %w", path, err)
+			}
+		}
+		for method, body := range item {
+			if method == "parameters" {
+				continue
+			}
+			var operation specOperation
+			err := json.Unmarshal(body, &operation)
+			if err != nil {
+				return nil, fmt.Errorf("openapi.json %s %s: %w", method, path, err)
+			}
+			// the operation's parameters override the path's ones with the same name
+			byName := make(map[string]*specParameter)
+			merged := append(append([]*specParameter{}, shared...), operation.Parameters...)
+			for _, parameter := range merged {
+				parameter, err := resolveParameter(parameter)
+				if err != nil {
+					return nil, err
+				}
+				byName[parameter.In+":"+parameter.Name] = parameter
+			}
+			operation.Parameters = make([]*specParameter, 0, len(byName))
+			for _, parameter := range byName {
+				operation.Parameters = append(operation.Parameters, parameter)
+			}
+			route.operations[strings.ToUpper(method)] = &operation
+		}
+		spec.routes = append(spec.routes, route)
+	}
+	return spec, nil
+}
+
+// the operation for a request and the values of its path parameters
+// a static segment beats a parameter like it does in the router (/v1/review/batch over /v1/review/{id})
+func (s *apiSpec) find(method string, path string) (*specOperation, map[string]string) {
+	segments := strings.Split(path, "/")
+	var best *specRoute
+	for _, route := range s.routes {
+		if len(route.segments) != len(segments) || route.operations[method] == nil {
+			continue
+		}
+		if routeMatches(route, segments) && (best == nil || route.static > best.static) {
+			best = route
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	values := make(map[string]string)
+	for i, segment := range best.segments {
+		if strings.HasPrefix(segment, "{") {
+			values[strings.Trim(segment, "{}")] = segments[i]
+		}
+	}
+	return best.operations[method], values
+}
+
+func routeMatches(route *specRoute, segments []string) bool {
+	for i, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const paramsContextKey = contextKey("params")
+
+// the values of the path and query parameters that were sent, in the type of their schema
+// (integers are int64 so big ids keep every digit, numbers float64, booleans bool, lists []any)
+type requestParams map[string]any
+
+// checks the request before it goes to the router, requests the document doesn't describe
+// are left to the router (404 or 405)
+func (a *applicationDependencies) validateRequest(spec *apiSpec, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation, pathValues := spec.find(r.Method, r.URL.Path)
+		if operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// without a token the route's requireToken sends a 401, that comes first
+		if len(operation.Security) > 0 && r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		params, pathFound, fieldErrors := spec.checkParameters(operation, r, pathValues)
+		if !pathFound {
+			a.notFoundResponse(w, r)
+			return
+		}
+		fieldErrors = append(fieldErrors, spec.checkBody(operation, r)...)
+		if len(fieldErrors) > 0 {
+			a.fieldErrorsResponse(w, r, fieldErrors)
+			return
+		}
+		ctx := context.WithValue(r.Context(), paramsContextKey, params)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// path and query parameters, the headers are read by the handlers and middleware that use them
+// pathFound is false when a path parameter doesn't match its schema
+func (s *apiSpec) checkParameters(operation *specOperation, r *http.Request, pathValues map[string]string) (params requestParams, pathFound bool, fieldErrors []fieldError) {
+	params = requestParams{}
+	query := r.URL.Query()
+	for _, parameter := range operation.Parameters {
+		var value string
+		switch parameter.In {
+		case "path":
+			value = pathValues[parameter.Name]
+		case "query":
+			// an empty value is the same as no value for the query string helpers
+			value = query.Get(parameter.Name)
+		default:
+			continue
+		}
+		if value == "" {
+			if parameter.Required {
+				fieldErrors = append(fieldErrors, fieldError{Parameter: parameter.Name, Detail: "must be provided"})
+			}
+			continue
+		}
+
+		typed, ok := s.parameterValue(parameter.Schema, value)
+		var problems []schemaError
+		if ok {
+			problems = s.validate(parameter.Schema, typed, "")
+		}
+		if parameter.In == "path" && (!ok || len(problems) > 0) {
+			return nil, false, nil
+		}
+		if !ok {
+			detail := typeMessage(s.resolve(parameter.Schema).Type)
+			fieldErrors = append(fieldErrors, fieldError{Parameter: parameter.Name, Detail: detail})
+			continue
+		}
+		for _, problem := range problems {
+			fieldErrors = append(fieldErrors, fieldError{Parameter: parameter.Name, Detail: problem.detail})
+		}
+		params[parameter.Name] = typed
+	}
+	return params, true, fieldErrors
+}
+
+// turns the text of a parameter into the type of its schema, lists are comma separated
+func (s *apiSpec) parameterValue(schema *jsonSchema, value string) (any, bool) {
+	schema = s.resolve(schema)
+	if schema == nil || len(schema.Type) == 0 {
+		return value, true
+	}
+	switch schema.Type[0] {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		return n, err == nil
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		return n, err == nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	case "array":
+		var list []any
+		for _, entry := range strings.Split(value, ",") {
+			item, ok := s.parameterValue(schema.Items, strings.TrimSpace(entry))
+			if !ok {
+				return nil, false
+			}
+			list = append(list, item)
+		}
+		return list, true
+	}
+	return value, true
+}
+
+// the JSON body, the request body is put back for the handler
+// bodies that can't be read as a single JSON value are left to readJson/readPatch which have
+// better errors for them (badly-formed JSON, too large, empty...)
+func (s *apiSpec) checkBody(operation *specOperation, r *http.Request) []fieldError {
+	if operation.RequestBody == nil || r.Body == nil {
+		return nil
+	}
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil
+		}
+	}
+	content, ok := operation.RequestBody.Content[mediaType]
+	if !ok {
+		// the handlers read any body as JSON unless they take more than one type (PATCH)
+		content, ok = operation.RequestBody.Content["application/json"]
+		if !ok || len(operation.RequestBody.Content) > 1 {
+			return nil
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 256_000+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > 256_000 {
+		return nil
+	}
+
+	var document any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	err = dec.Decode(&document)
+	if err != nil || dec.Decode(&struct{}{}) != io.EOF {
+		return nil
+	}
+
+	var fieldErrors []fieldError
+	for _, problem := range s.validate(content.Schema, document, "") {
+		if problem.pointer == "" {
+			fieldErrors = append(fieldErrors, fieldError{Detail: "the body " + problem.detail})
+			continue
+		}
+		fieldErrors = append(fieldErrors, fieldError{Pointer: problem.pointer, Detail: problem.detail})
+	}
+	return fieldErrors
+}
+
+func (s *apiSpec) resolve(schema *jsonSchema) *jsonSchema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// a value that doesn't match its schema, pointer is where it is in the document
+type schemaError struct {
+	pointer string
+	detail  string
+}
+
+// checks a value decoded from JSON (numbers are float64) or a parameter (see requestParams) against a schema
+func (s *apiSpec) validate(schema *jsonSchema, value any, pointer string) []schemaError {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+	var problems []schemaError
+	for _, part := range schema.AllOf {
+		problems = append(problems, s.validate(part, value, pointer)...)
+	}
+	if len(schema.Type) > 0 && !schema.Type.allows(value) {
+		return append(problems, schemaError{pointer, typeMessage(schema.Type)})
+	}
+	if schema.Enum != nil && !enumContains(schema.Enum, value) {
+		return append(problems, schemaError{pointer, "must be one of " + enumList(schema.Enum)})
+	}
+
+	switch value := value.(type) {
+	case float64:
+		if (schema.Minimum != nil && value < *schema.Minimum) || (schema.Maximum != nil && value > *schema.Maximum) {
+			problems = append(problems, schemaError{pointer, rangeMessage(schema.Minimum, schema.Maximum)})
+		}
+	case int64:
+		if (schema.Minimum != nil && float64(value) < *schema.Minimum) || (schema.Maximum != nil && float64(value) > *schema.Maximum) {
+			problems = append(problems, schemaError{pointer, rangeMessage(schema.Minimum, schema.Maximum)})
+		}
+	case string:
+		if schema.MaxLength != nil && len([]rune(value)) > *schema.MaxLength {
+			problems = append(problems, schemaError{pointer, fmt.Sprintf("must not be more than %d characters long", *schema.MaxLength)})
+		}
+		if schema.Format == "date-time" {
+			_, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				problems = append(problems, schemaError{pointer, "must be an RFC 3339 date and time"})
+			}
+		}
+	case []any:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			problems = append(problems, schemaError{pointer, fmt.Sprintf("must contain at least %d entries", *schema.MinItems)})
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			problems = append(problems, schemaError{pointer, fmt.Sprintf("must not contain more than %d entries", *schema.MaxItems)})
+		}
+		for i, item := range value {
+			problems = append(problems, s.validate(schema.Items, item, fmt.Sprintf("%s/%d", pointer, i))...)
+		}
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				problems = append(problems, schemaError{pointer + "/" + escapePointer(name), "must be provided"})
+			}
+		}
+		closed := string(schema.AdditionalProperties) == "false"
+		for name, property := range value {
+			propertySchema, known := schema.Properties[name]
+			if !known {
+				if closed {
+					problems = append(problems, schemaError{pointer + "/" + escapePointer(name), "is not a known field"})
+				}
+				continue
+			}
+			problems = append(problems, s.validate(propertySchema, property, pointer+"/"+escapePointer(name))...)
+		}
+	}
+	return problems
+}
+
+// the JSON type names of a value, a whole number is an integer and a number
+func (t schemaTypes) allows(value any) bool {
+	var names []string
+	switch value := value.(type) {
+	case nil:
+		names = []string{"null"}
+	case bool:
+		names = []string{"boolean"}
+	case float64:
+		names = []string{"number"}
+		if value == math.Trunc(value) && !math.IsInf(value, 0) {
+			names = append(names, "integer")
+		}
+	case int64:
+		names = []string{"number", "integer"}
+	case string:
+		names = []string{"string"}
+	case []any:
+		names = []string{"array"}
+	case map[string]any:
+		names = []string{"object"}
+	}
+	for _, name := range names {
+		for _, allowed := range t {
+			if name == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// same wording as the query string helpers for the types they read
+func typeMessage(types schemaTypes) string {
+	descriptions := map[string]string{
+		"string":  "a string",
+		"integer": "an integer value",
+		"number":  "a number",
+		"boolean": "a boolean value",
+		"object":  "an object",
+		"array":   "an array",
+		"null":    "null",
+	}
+	var parts []string
+	for _, name := range types {
+		parts = append(parts, descriptions[name])
+	}
+	return "must be " + strings.Join(parts, " or ")
+}
+
+func rangeMessage(minimum *float64, maximum *float64) string {
+	format := func(n *float64) string { return strconv.FormatFloat(*n, 'f', -1, 64) }
+	switch {
+	case minimum != nil && maximum != nil:
+		return fmt.Sprintf("must be between %s and %s", format(minimum), format(maximum))
+	case minimum != nil:
+		return fmt.Sprintf("must be at least %s", format(minimum))
+	default:
+		return fmt.Sprintf("must be a maximum of %s", format(maximum))
+	}
+}
+
+func enumContains(enum []any, value any) bool {
+	// the enum comes from JSON, its numbers are float64
+	if n, ok := value.(int64); ok {
+		value = float64(n)
+	}
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []any) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// a property name as a JSON pointer token
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/ReynerioSamos/reviews/internal/webhook"
+)
+
+// subscriptions to the review and product events for other systems (e.g. the CRM)
+// the deliveries are sent by the dispatcher (see dispatcher.go)
+
+func (a *applicationDependencies) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		URL    string   `json:"url"`
+		Secret *string  `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	// a secret is made up when the client doesn't bring one, it's only sent back this once
+	hook := &data.Webhook{
+		URL:    incomingData.URL,
+		Secret: webhook.NewSecret(),
+		Events: incomingData.Events,
+		Active: true,
+	}
+	if incomingData.Secret != nil {
+		hook.Secret = *incomingData.Secret
+	}
+	if incomingData.Active != nil {
+		hook.Active = *incomingData.Active
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, hook)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.webhookModel.Insert(hook)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/webhooks/%d", hook.ID))
+
+	data := envelope{
+		"webhook": hook,
+		"secret":  hook.Secret,
+	}
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := data.Filters{
+		Page:     a.getSingleIntegerParameter(r, "page", 1),
+		PageSize: a.getSingleIntegerParameter(r, "page_size", 10),
+		// subscriptions are always oldest first
+		Sort:         "id",
+		SortSafeList: []string{"id"},
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhooks, metadata, err := a.webhookModel.GetAll(filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"webhooks":  webhooks,
+		"@metadata": metadata,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	hook, err := a.webhookModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"webhook": hook,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	hook, err := a.webhookModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !a.ifMatch(r, hook.Version) {
+		a.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// pointers so a field that isn't sent is left alone
+	var incomingData struct {
+		URL    *string  `json:"url"`
+		Secret *string  `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err = a.readJson(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if incomingData.URL != nil {
+		hook.URL = *incomingData.URL
+	}
+	if incomingData.Secret != nil {
+		hook.Secret = *incomingData.Secret
+	}
+	if incomingData.Events != nil {
+		hook.Events = incomingData.Events
+	}
+	if incomingData.Active != nil {
+		hook.Active = *incomingData.Active
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, hook)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.webhookModel.Update(hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"webhook": hook,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.webhookModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "webhook successfully deleted",
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// the delivery log of a webhook with every attempt, ?status= narrows it down to e.g. the failed ones
+func (a *applicationDependencies) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	status := a.getSingleQueryParameter(r, "status", "")
+	filters := data.Filters{
+		Page:     a.getSingleIntegerParameter(r, "page", 1),
+		PageSize: a.getSingleIntegerParameter(r, "page_size", 10),
+		// the log is always newest first
+		Sort:         "-id",
+		SortSafeList: []string{"-id"},
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// an unknown webhook is a 404 rather than an empty log
+	_, err = a.webhookModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deliveries, metadata, err := a.webhookModel.GetDeliveries(id, status, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"deliveries": deliveries,
+		"@metadata":  metadata,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// sends a delivery again straight away, e.g. once the receiver is fixed after it ran out of attempts
+func (a *applicationDependencies) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, err := a.readIntParam(r, "delivery")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := a.webhookModel.GetDelivery(id, int64(deliveryID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.deliverWebhook(delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"delivery": delivery,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// which column (CSV header) or key (JSON Lines) holds each field
+// fields left out of the config are read from a column with the field's own name
+type mapping struct {
+	ListSeparator string            `json:"list_separator"` // separates pros/cons entries in a CSV cell, | by default
+	Products      map[string]string `json:"products"`       // ref, pname, product_category, image_url
+	Reviews       map[string]string `json:"reviews"`        // prod_id, product_ref, rating, body, pros, cons, author
+}
+
+func loadMapping(path string) (*mapping, error) {
+	m := &mapping{}
+	if path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(file))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(m)
+		if err != nil {
+			return nil, fmt.Errorf("reading mapping %s: %w", path, err)
+		}
+	}
+	if m.ListSeparator == "" {
+		m.ListSeparator = "|"
+	}
+	return m, nil
+}
+
+// the column a field is read from
+func column(columns map[string]string, field string) string {
+	if name, ok := columns[field]; ok {
+		return name
+	}
+	return field
+}
+
+// one row of the input file
+type record struct {
+	line   int            // line number in the file, for the reject file
+	values map[string]any // column -> value, strings for CSV and whatever the JSON held for JSON Lines
+	raw    string         // the row as it was in the file
+}
+
+// reads records one at a time so the file never has to fit in memory
+type source interface {
+	Next() (*record, error) // io.EOF after the last record
+}
+
+// picks the reader from -format or, without it, from the file extension
+func openSource(path string, format string) (source, io.Closer, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			return nil, nil, fmt.Errorf("can't tell the format of %s, use -format csv or -format jsonl", path)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case "csv":
+		src, err := newCSVSource(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return src, file, nil
+	case "jsonl":
+		return newJSONLSource(file), file, nil
+	default:
+		file.Close()
+		return nil, nil, fmt.Errorf("unknown format %q, must be csv or jsonl", format)
+	}
+}
+
+type csvSource struct {
+	reader *csv.Reader
+	header []string
+}
+
+// the first row of the file has to be the header
+func newCSVSource(r io.Reader) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	return &csvSource{reader: reader, header: header}, nil
+}
+
+func (s *csvSource) Next() (*record, error) {
+	row, err := s.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	line, _ := s.reader.FieldPos(0)
+
+	rec := &record{line: line, values: map[string]any{}}
+	for i, value := range row {
+		if i < len(s.header) {
+			rec.values[s.header[i]] = value
+		}
+	}
+
+	var raw strings.Builder
+	w := csv.NewWriter(&raw)
+	w.Write(row)
+	w.Flush()
+	rec.raw = strings.TrimSuffix(raw.String(), "\n")
+	return rec, nil
+}
+
+type jsonlSource struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newJSONLSource(r io.Reader) *jsonlSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &jsonlSource{scanner: scanner}
+}
+
+// a line that isn't a JSON object still comes back as a record (with no values) so it ends up
+// in the reject file instead of stopping the import
+func (s *jsonlSource) Next() (*record, error) {
+	for s.scanner.Scan() {
+		s.line++
+		text := strings.TrimSpace(s.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		rec := &record{line: s.line, raw: text}
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+		err := dec.Decode(&rec.values)
+		if err != nil {
+			rec.values = nil
+		}
+		return rec, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+var errMissing = errors.New("missing")
+
+// the value of a column as a string, numbers are formatted back to text
+func (rec *record) str(name string) (string, error) {
+	value, ok := rec.values[name]
+	if !ok || value == nil {
+		return "", errMissing
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", errors.New("must be a string")
+	}
+}
+
+// the value of a column as an integer, empty means missing
+func (rec *record) int(name string) (int64, error) {
+	value, err := rec.str(name)
+	if err != nil {
+		return 0, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, errMissing
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("must be an integer value")
+	}
+	return n, nil
+}
+
+// the value of a column as a list, a JSON array or a CSV cell split on the separator
+func (rec *record) list(name string, separator string) ([]string, error) {
+	value, ok := rec.values[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		entries := strings.Split(v, separator)
+		for i := range entries {
+			entries[i] = strings.TrimSpace(entries[i])
+		}
+		return entries, nil
+	case []any:
+		entries := make([]string, len(v))
+		for i, entry := range v {
+			s, ok := entry.(string)
+			if !ok {
+				return nil, errors.New("must be a list of strings")
+			}
+			entries[i] = s
+		}
+		return entries, nil
+	default:
+		return nil, errors.New("must be a list of strings")
+	}
+}
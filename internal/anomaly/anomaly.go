@@ -0,0 +1,61 @@
+package anomaly
+
+import "time"
+
+// kinds of anomalies the detector reports
+const (
+	KindVelocity     = "velocity"     // far more reviews than usual in the window
+	KindDistribution = "distribution" // the share of low ratings jumped compared to the baseline
+)
+
+// ratings at or below this are counted as "low"
+const LowRating = 2
+
+// review counts for a single product
+// recent is the sliding window that ends now, baseline is the period right before it
+type Stats struct {
+	Recent      int // reviews in the window (including the one being checked)
+	RecentLow   int // low ratings in the window
+	Baseline    int // reviews in the baseline period
+	BaselineLow int // low ratings in the baseline period
+}
+
+type Detector struct {
+	Window         time.Duration // length of the sliding window e.g. 1h
+	Baseline       time.Duration // how far back before the window the normal rate is measured e.g. 7 days
+	MinReviews     int           // nothing is reported for windows with fewer reviews than this
+	VelocityFactor float64       // how many times the baseline rate counts as a burst
+	LowShareShift  float64       // how much the share of low ratings has to grow e.g. 0.5 = +50 points
+}
+
+// Check reports whether the product is being review bombed and what kind of anomaly it is
+func (d Detector) Check(s Stats) (kind string, detected bool) {
+	if d.MinReviews <= 0 || s.Recent < d.MinReviews {
+		return "", false
+	}
+
+	// the baseline rate scaled to the size of the window, a product without
+	// any history gets a rate of 1 so a brand new product isn't instantly flagged
+	expected := 1.0
+	if d.Baseline > 0 && s.Baseline > 0 {
+		expected = float64(s.Baseline) * float64(d.Window) / float64(d.Baseline)
+		if expected < 1 {
+			expected = 1
+		}
+	}
+	if float64(s.Recent) >= d.VelocityFactor*expected {
+		return KindVelocity, true
+	}
+
+	// the window doesn't need to be unusually busy if it is unusually negative
+	recentShare := float64(s.RecentLow) / float64(s.Recent)
+	baselineShare := 0.0
+	if s.Baseline > 0 {
+		baselineShare = float64(s.BaselineLow) / float64(s.Baseline)
+	}
+	if s.Baseline > 0 && recentShare-baselineShare >= d.LowShareShift {
+		return KindDistribution, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/anomaly"
+)
+
+// an open (or resolved) review bombing alert for a product
+type Anomaly struct {
+	ID                 int64      `json:"id"`
+	Prod_ID            int64      `json:"prod_id"`
+	ProductName        string     `json:"product_name"`
+	Kind               string     `json:"kind"`               // velocity or distribution
+	Recent_Count       int        `json:"recent_count"`       // reviews in the window when last seen
+	Recent_Low_Count   int        `json:"recent_low_count"`   // low ratings in the window when last seen
+	Baseline_Count     int        `json:"baseline_count"`     // reviews in the baseline period when last seen
+	Baseline_Low_Count int        `json:"baseline_low_count"` // low ratings in the baseline period when last seen
+	Held_Reviews       int        `json:"held_reviews"`       // reviews sent to moderation because of it
+	DetectedAt         time.Time  `json:"detected_at"`
+	LastSeenAt         time.Time  `json:"last_seen_at"`
+	ResolvedAt         *time.Time `json:"resolved_at,omitempty"`
+}
+
+type AnomalyModel struct {
+	DB *sql.DB
+}
+
+// GetAll lists the alerts, newest first. Open alerts only unless resolved is true
+func (m AnomalyModel) GetAll(resolved bool, filters Filters) ([]*Anomaly, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), a.id, a.prod_id, p.pname, a.kind,
+			a.recent_count, a.recent_low_count, a.baseline_count, a.baseline_low_count,
+			a.held_reviews, a.detected_at, a.last_seen_at, a.resolved_at
+		FROM review_anomalies a
+		JOIN product p ON p.pid = a.prod_id
+		WHERE (a.resolved_at IS NOT NULL) = $1
+		ORDER BY a.last_seen_at DESC, a.id DESC
+		LIMIT $2 OFFSET $3
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, resolved, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("querying anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	anomalies := []*Anomaly{}
+	for rows.Next() {
+		var a Anomaly
+		err := rows.Scan(
+			&totalRecords,
+			&a.ID,
+			&a.Prod_ID,
+			&a.ProductName,
+			&a.Kind,
+			&a.Recent_Count,
+			&a.Recent_Low_Count,
+			&a.Baseline_Count,
+			&a.Baseline_Low_Count,
+			&a.Held_Reviews,
+			&a.DetectedAt,
+			&a.LastSeenAt,
+			&a.ResolvedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, fmt.Errorf("scanning anomaly row: %w", err)
+		}
+		anomalies = append(anomalies, &a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return anomalies, metadata, nil
+}
+
+// Resolve closes an open alert, the next burst on the product opens a new one
+func (m AnomalyModel) Resolve(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE review_anomalies
+		SET resolved_at = NOW()
+		WHERE id = $1 AND resolved_at IS NULL
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("resolving anomaly: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// checkAnomaly runs the detector against the product of a review that is about to be inserted
+// when a burst is detected it is recorded (or added to the open alert) and its kind is returned
+// an empty kind means the review can be published
+func checkAnomaly(ctx context.Context, tx *sql.Tx, detector anomaly.Detector, review *Review) (string, error) {
+	if detector.MinReviews <= 0 {
+		return "", nil
+	}
+
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at >= NOW() - make_interval(secs => $2)),
+			COUNT(*) FILTER (WHERE created_at >= NOW() - make_interval(secs => $2) AND rating <= $4),
+			COUNT(*) FILTER (WHERE created_at < NOW() - make_interval(secs => $2)),
+			COUNT(*) FILTER (WHERE created_at < NOW() - make_interval(secs => $2) AND rating <= $4)
+		FROM review
+		WHERE prod_id = $1
+		AND created_at >= NOW() - make_interval(secs => $2 + $3)
+		AND deleted_at IS NULL
+		AND status <> 'rejected'
+		`
+
+	var stats anomaly.Stats
+	err := tx.QueryRowContext(ctx, query,
+		review.Prod_ID,
+		detector.Window.Seconds(),
+		detector.Baseline.Seconds(),
+		anomaly.LowRating,
+	).Scan(&stats.Recent, &stats.RecentLow, &stats.Baseline, &stats.BaselineLow)
+	if err != nil {
+		return "", fmt.Errorf("counting recent reviews: %w", err)
+	}
+
+	// the review being checked is part of the window
+	stats.Recent++
+	if review.Rating <= anomaly.LowRating {
+		stats.RecentLow++
+	}
+
+	kind, detected := detector.Check(stats)
+	if !detected {
+		return "", nil
+	}
+
+	alertQuery := `
+		INSERT INTO review_anomalies (prod_id, kind, recent_count, recent_low_count, baseline_count, baseline_low_count)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (prod_id) WHERE resolved_at IS NULL
+		DO UPDATE SET
+			kind = EXCLUDED.kind,
+			recent_count = EXCLUDED.recent_count,
+			recent_low_count = EXCLUDED.recent_low_count,
+			baseline_count = EXCLUDED.baseline_count,
+			baseline_low_count = EXCLUDED.baseline_low_count,
+			held_reviews = review_anomalies.held_reviews + 1,
+			last_seen_at = NOW()
+		`
+	_, err = tx.ExecContext(ctx, alertQuery,
+		review.Prod_ID,
+		kind,
+		stats.Recent,
+		stats.RecentLow,
+		stats.Baseline,
+		stats.BaselineLow,
+	)
+	if err != nil {
+		return "", fmt.Errorf("recording anomaly: %w", err)
+	}
+	return kind, nil
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// *sql.DB and *sql.Tx, so the same queries can run on their own or as part of a batch
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// errors that only concern one item of a batch, anything else fails the whole batch
+func isItemError(err error) bool {
+	var duplicate *DuplicateReviewError
+	return errors.Is(err, ErrEditConflict) || errors.Is(err, ErrRecordNotFound) || errors.As(err, &duplicate)
+}
+
+// batches get more time than a single query, but not forever
+func batchTimeout(items int) time.Duration {
+	return defaultTimeout + time.Duration(items)*100*time.Millisecond
+}
+
+// runs save for every item inside one transaction
+// atomic: the first item error rolls everything back
+// partial: a failing item is rolled back to a savepoint and the rest carry on
+// the returned slice has the item errors by index (nil for items that were saved),
+// the error is for failures that aren't down to a single item
+func runBatch(ctx context.Context, tx *sql.Tx, items int, partial bool, save func(i int) error) ([]error, error) {
+	itemErrors := make([]error, items)
+	for i := 0; i < items; i++ {
+		if partial {
+			_, err := tx.ExecContext(ctx, "SAVEPOINT batch_item")
+			if err != nil {
+				return nil, fmt.Errorf("creating savepoint: %w", err)
+			}
+		}
+
+		err := save(i)
+		switch {
+		case err == nil:
+			if partial {
+				_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item")
+				if err != nil {
+					return nil, fmt.Errorf("releasing savepoint: %w", err)
+				}
+			}
+		case !isItemError(err):
+			return nil, err
+		case partial:
+			itemErrors[i] = err
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item")
+			if err != nil {
+				return nil, fmt.Errorf("rolling back to savepoint: %w", err)
+			}
+		default:
+			itemErrors[i] = err
+			return itemErrors, nil
+		}
+	}
+	return itemErrors, nil
+}
+
+// true when any item of the batch failed
+func batchFailed(itemErrors []error) bool {
+	for _, err := range itemErrors {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveBatch inserts the products without a PID and updates (with the usual version check) the ones with one
+// in atomic mode nothing is committed if any item fails, see runBatch
+func (p ProductModel) SaveBatch(products []*Product, partial bool) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout(len(products)))
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	itemErrors, err := runBatch(ctx, tx, len(products), partial, func(i int) error {
+		if products[i].PID == 0 {
+			return insertProduct(ctx, tx, products[i])
+		}
+		return updateProduct(ctx, tx, products[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !partial && batchFailed(itemErrors) {
+		return itemErrors, nil
+	}
+
+	var events []Event
+	for i, product := range products {
+		if itemErrors[i] != nil {
+			continue
+		}
+		// a new product is at version 1, an update always moves it past that
+		if product.Version == 1 {
+			events = append(events, newEvent(EventProductCreated, product))
+		} else {
+			events = append(events, newEvent(EventProductUpdated, product))
+		}
+	}
+	err = writeOutbox(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return itemErrors, nil
+}
+
+// SaveBatch inserts the reviews without a RID and updates the ones with one, going through the same
+// duplicate, anomaly, sentiment and revision handling as Insert and Update
+// avg_rating is only recalculated once for every product the batch touched
+func (r ReviewModel) SaveBatch(reviews []*Review, partial bool) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout(len(reviews)))
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the products are locked in pid order before anything is written, two batches going through
+	// the same products in a different order would otherwise each hold a row the other waits on
+	prodIDs := make([]int64, 0, len(reviews))
+	for _, review := range reviews {
+		prodIDs = append(prodIDs, review.Prod_ID)
+	}
+	slices.Sort(prodIDs)
+	prodIDs = slices.Compact(prodIDs)
+	_, err = tx.ExecContext(ctx, `SELECT pid FROM product WHERE pid = ANY($1) ORDER BY pid FOR UPDATE`, pq.Array(prodIDs))
+	if err != nil {
+		return nil, fmt.Errorf("locking products: %w", err)
+	}
+
+	itemErrors, err := runBatch(ctx, tx, len(reviews), partial, func(i int) error {
+		if reviews[i].RID == 0 {
+			return r.insert(ctx, tx, reviews[i])
+		}
+		return r.update(ctx, tx, reviews[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !partial && batchFailed(itemErrors) {
+		return itemErrors, nil
+	}
+
+	var events []Event
+	for i, review := range reviews {
+		if itemErrors[i] != nil {
+			continue
+		}
+		// a new review is at version 1, an update always moves it past that
+		if review.Version == 1 {
+			events = append(events, reviewEvents(EventReviewCreated, review)...)
+		} else {
+			events = append(events, reviewEvents(EventReviewUpdated, review)...)
+		}
+	}
+
+	// avg_rating is recalculated in the same pid order
+	touched := map[int64]bool{}
+	for i, review := range reviews {
+		if itemErrors[i] == nil {
+			touched[review.Prod_ID] = true
+		}
+	}
+	for _, prodID := range prodIDs {
+		if !touched[prodID] {
+			continue
+		}
+		rating, err := ratingChange(ctx, tx, prodID)
+		if err != nil {
+			return nil, err
+		}
+		events = withRatingChange(events, rating)
+	}
+
+	err = writeOutbox(ctx, tx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return itemErrors, nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/fingerprint"
+	"github.com/lib/pq"
+)
+
+// all the text of a review: the body plus the pros and cons
+func reviewText(review *Review) string {
+	parts := append([]string{review.Body}, review.Pros...)
+	parts = append(parts, review.Cons...)
+	return strings.Join(parts, " ")
+}
+
+// the fingerprint covers all of the text
+func reviewFingerprint(review *Review) (uint64, bool) {
+	return fingerprint.Simhash(reviewText(review))
+}
+
+// findDuplicate looks for an existing review whose fingerprint is within fingerprint.MaxDistance bits
+// candidates are found through the band index and the distance is worked out in the query
+// (the 1 bits of the XOR), so every review sharing a band is looked at and not only the latest ones
+// a match from the same author wins over one from someone else, then the closest one does
+// nil means there's no duplicate
+func findDuplicate(ctx context.Context, tx *sql.Tx, fp uint64, author string) (*DuplicateReviewError, error) {
+	query := `
+		SELECT rid, same_author
+		FROM (
+			SELECT rid, $2 <> '' AND author = $2 AS same_author,
+				length(replace((fingerprint # $3)::bit(64)::text, '0', '')) AS distance
+			FROM review
+			WHERE fingerprint_bands && $1
+			AND fingerprint IS NOT NULL
+			AND deleted_at IS NULL
+			AND status <> 'rejected'
+		) AS candidates
+		WHERE distance <= $4
+		ORDER BY same_author DESC, distance ASC, rid DESC
+		LIMIT 1
+		`
+
+	var match DuplicateReviewError
+	err := tx.QueryRowContext(ctx, query, pq.Array(fingerprint.Bands(fp)), author, int64(fp), fingerprint.MaxDistance).
+		Scan(&match.DuplicateOf, &match.SameAuthor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("querying duplicate candidates: %w", err)
+	}
+	return &match, nil
+}
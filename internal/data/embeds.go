@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/lib/pq"
+)
+
+// related data the product endpoints can embed with ?include=, each is loaded for a
+// whole page of products in one query instead of one query per product
+
+// RatingSummary is the breakdown of a product's published reviews by star rating
+type RatingSummary struct {
+	Prod_ID      int64       `json:"prod_id"`
+	Count        int         `json:"count"`
+	Average      float64     `json:"average"`      // rounded to 2 decimals like avg_rating
+	Distribution map[int]int `json:"distribution"` // rating -> number of reviews, every rating from 1 to 5 is there
+}
+
+// Latest returns the newest published reviews of each product, at most limit per product
+// every product in prodIDs is in the map, with an empty slice if it has no reviews
+func (r ReviewModel) Latest(prodIDs []int64, limit int) (map[int64][]*Review, error) {
+	return r.Top(prodIDs, limit, Filters{Sort: "-created_at", SortSafeList: []string{"-created_at"}})
+}
+
+// Top is Latest in the order of filters.Sort (checked against filters.SortSafeList),
+// e.g. -helpful_count for the most helpful reviews of each product
+func (r ReviewModel) Top(prodIDs []int64, limit int, filters Filters) (map[int64][]*Review, error) {
+	latest := make(map[int64][]*Review, len(prodIDs))
+	for _, id := range prodIDs {
+		latest[id] = []*Review{}
+	}
+	if len(prodIDs) == 0 {
+		return latest, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT `+reviewColumns+`
+		FROM (
+			SELECT review.*,
+				ROW_NUMBER() OVER (PARTITION BY prod_id ORDER BY %s %s, rid DESC) AS position
+			FROM review
+			WHERE prod_id = ANY($1) AND deleted_at IS NULL AND status = 'published'
+		) r
+		JOIN product p ON p.pid = r.prod_id
+		WHERE r.position <= $2
+		ORDER BY r.prod_id, r.position
+		`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(prodIDs), limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying top reviews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var review Review
+		err := scanReview(rows, &review)
+		if err != nil {
+			return nil, fmt.Errorf("scanning review row: %w", err)
+		}
+		latest[review.Prod_ID] = append(latest[review.Prod_ID], &review)
+	}
+	return latest, rows.Err()
+}
+
+// GetMany reads the products with the given ids, deleted or unknown ids aren't in the map
+func (p ProductModel) GetMany(ids []int64) (map[int64]*Product, error) {
+	products := make(map[int64]*Product, len(ids))
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	columns, _ := productSelect(&Product{}, nil)
+	query := `
+		SELECT ` + columns + `
+		FROM product
+		WHERE pid = ANY($1) AND deleted_at IS NULL
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("querying products: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var product Product
+		_, dest := productSelect(&product, nil)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("scanning product row: %w", err)
+		}
+		products[product.PID] = &product
+	}
+	return products, rows.Err()
+}
+
+// RatingSummaries counts the published reviews of each product by rating
+// every product in prodIDs is in the map, with a count of 0 if it has no reviews
+func (r ReviewModel) RatingSummaries(prodIDs []int64) (map[int64]*RatingSummary, error) {
+	summaries := make(map[int64]*RatingSummary, len(prodIDs))
+	for _, id := range prodIDs {
+		summaries[id] = &RatingSummary{
+			Prod_ID:      id,
+			Distribution: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0},
+		}
+	}
+	if len(prodIDs) == 0 {
+		return summaries, nil
+	}
+
+	query := `
+		SELECT prod_id, rating, COUNT(*)
+		FROM review
+		WHERE prod_id = ANY($1) AND deleted_at IS NULL AND status = 'published'
+		GROUP BY prod_id, rating
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(prodIDs))
+	if err != nil {
+		return nil, fmt.Errorf("querying rating summaries: %w", err)
+	}
+	defer rows.Close()
+
+	totals := make(map[int64]int)
+	for rows.Next() {
+		var prodID int64
+		var rating, count int
+		err := rows.Scan(&prodID, &rating, &count)
+		if err != nil {
+			return nil, fmt.Errorf("scanning rating summary row: %w", err)
+		}
+		summary := summaries[prodID]
+		summary.Distribution[rating] = count
+		summary.Count += count
+		totals[prodID] += rating * count
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, total := range totals {
+		summary := summaries[id]
+		summary.Average = math.Round(float64(total)/float64(summary.Count)*100) / 100
+	}
+	return summaries, nil
+}
@@ -5,3 +5,6 @@ import (
 )
 
 var ErrRecordNotFound = errors.New("record not found")
+
+// the record was changed by someone else between reading and updating it
+var ErrEditConflict = errors.New("edit conflict")
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// the changes other systems can subscribe to, they're written to the outbox with the change
+// itself (see outbox.go) and published from there to the webhooks and the other sinks
+const (
+	EventReviewCreated   = "review.created"
+	EventReviewUpdated   = "review.updated"
+	EventReviewDeleted   = "review.deleted"
+	EventReviewRestored  = "review.restored" // a soft deleted review is back
+	EventProductCreated  = "product.created"
+	EventProductUpdated  = "product.updated"
+	EventProductDeleted  = "product.deleted"
+	EventProductRestored = "product.restored"       // a soft deleted product is back, with its reviews
+	EventRatingChanged   = "product.rating_changed" // a product's avg_rating moved
+)
+
+var EventTypes = []string{
+	EventReviewCreated, EventReviewUpdated, EventReviewDeleted, EventReviewRestored,
+	EventProductCreated, EventProductUpdated, EventProductDeleted, EventProductRestored, EventRatingChanged,
+}
+
+// something that happened to a review or a product, the ID is the same in every delivery of it
+// so a receiver can tell a retry from a new event
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+func newEvent(eventType string, data any) Event {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return Event{ID: hex.EncodeToString(b), Type: eventType, OccurredAt: time.Now().UTC(), Data: data}
+}
+
+// the event of a review that carries the review itself, none while it's pending or rejected
+// since subscribers only hear about reviews the public can see, SetStatus sends one when a
+// held review gets published
+func reviewEvents(eventType string, review *Review) []Event {
+	if review.Status != ReviewPublished {
+		return nil
+	}
+	return []Event{newEvent(eventType, review)}
+}
+
+// the data of a review.deleted event, the review itself is gone
+type DeletedReview struct {
+	RID     int64 `json:"rid"`
+	Prod_ID int64 `json:"prod_id"`
+}
+
+// the data of a product.deleted event
+type DeletedProduct struct {
+	PID int64 `json:"pid"`
+}
+
+// the data of a product.rating_changed event
+type RatingChange struct {
+	PID            int64   `json:"pid"`
+	Old_Avg_Rating float32 `json:"old_avg_rating"`
+	Avg_Rating     float32 `json:"avg_rating"`
+}
+
+// the product's avg_rating changed by a review, as an event (nil when it didn't move)
+func ratingChange(ctx context.Context, tx *sql.Tx, prodID int64) (*Event, error) {
+	var change RatingChange
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(avg_rating, 0) FROM product WHERE pid = $1 FOR UPDATE`, prodID).
+		Scan(&change.Old_Avg_Rating)
+	if err != nil {
+		return nil, fmt.Errorf("reading avg_rating: %w", err)
+	}
+
+	err = updateAvgRating(ctx, tx, prodID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(avg_rating, 0) FROM product WHERE pid = $1`, prodID).
+		Scan(&change.Avg_Rating)
+	if err != nil {
+		return nil, fmt.Errorf("reading avg_rating: %w", err)
+	}
+	if change.Avg_Rating == change.Old_Avg_Rating {
+		return nil, nil
+	}
+	change.PID = prodID
+	event := newEvent(EventRatingChanged, change)
+	return &event, nil
+}
+
+// adds the rating change to the events of a change when there is one
+func withRatingChange(events []Event, rating *Event) []Event {
+	if rating != nil {
+		events = append(events, *rating)
+	}
+	return events
+}
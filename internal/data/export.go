@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// rows fetched per query while exporting, the export walks the table by id
+// (WHERE id > last id seen) so it only ever holds one chunk in memory, never has to
+// skip over rows with OFFSET and doesn't keep a connection busy while a slow client reads
+const exportChunkSize = 500
+
+// Export calls fn for every product matching the same filters as GetAll, in pid order
+// it stops at the first error from fn or when ctx is cancelled (e.g. the client went away)
+func (p ProductModel) Export(ctx context.Context, pname string, product_category string, avg_rating float32, fn func(*Product) error) error {
+	query := `
+		SELECT pid, created_at, pname, product_category, image_URL, avg_rating, version, updated_at
+		FROM product
+		WHERE ` + productListFilters + `
+		AND pid > $4
+		ORDER BY pid
+		LIMIT $5
+		`
+
+	var after int64
+	for {
+		products, err := p.exportChunk(ctx, query, pname, product_category, avgRatingFilter(avg_rating), after)
+		if err != nil {
+			return err
+		}
+		for _, product := range products {
+			err = fn(product)
+			if err != nil {
+				return err
+			}
+			after = product.PID
+		}
+		if len(products) < exportChunkSize {
+			return nil
+		}
+	}
+}
+
+func (p ProductModel) exportChunk(ctx context.Context, query string, pname, product_category, avgRating string, after int64) ([]*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, pname, product_category, avgRating, after, exportChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("querying products: %w", err)
+	}
+	defer rows.Close()
+
+	products := make([]*Product, 0, exportChunkSize)
+	for rows.Next() {
+		var product Product
+		err := rows.Scan(
+			&product.PID,
+			&product.CreatedAt,
+			&product.Pname,
+			&product.Product_Category,
+			&product.Image_URL,
+			&product.Avg_Rating,
+			&product.Version,
+			&product.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scanning product row: %w", err)
+		}
+		products = append(products, &product)
+	}
+	return products, rows.Err()
+}
+
+// Export calls fn for every published review matching the same filters as GetAll, in rid order
+// q.Preferred is ignored, an export isn't ordered by language
+func (r ReviewModel) Export(ctx context.Context, q ReviewQuery, fn func(*Review) error) error {
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM review r
+		JOIN product p ON p.pid = r.prod_id
+		WHERE ` + reviewListFilters + `
+		AND r.rid > $4
+		ORDER BY r.rid
+		LIMIT $5
+		`
+
+	var after int64
+	for {
+		reviews, err := r.exportChunk(ctx, query, q, after)
+		if err != nil {
+			return err
+		}
+		for _, review := range reviews {
+			err = fn(review)
+			if err != nil {
+				return err
+			}
+			after = review.RID
+		}
+		if len(reviews) < exportChunkSize {
+			return nil
+		}
+	}
+}
+
+func (r ReviewModel) exportChunk(ctx context.Context, query string, q ReviewQuery, after int64) ([]*Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query,
+		q.Prod_ID, q.Rating, q.Helpful_Count,
+		after, exportChunkSize,
+		q.Verified, q.Sentiment, q.Search, q.Language)
+	if err != nil {
+		return nil, fmt.Errorf("querying reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make([]*Review, 0, exportChunkSize)
+	for rows.Next() {
+		var review Review
+		err := scanReview(rows, &review)
+		if err != nil {
+			return nil, fmt.Errorf("scanning review row: %w", err)
+		}
+		reviews = append(reviews, &review)
+	}
+	return reviews, rows.Err()
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// a request sent with an Idempotency-Key header and, once it's been handled, the response to it
+// keys are scoped to the method and path so the same key on two endpoints doesn't collide
+type IdempotencyRecord struct {
+	Key         string
+	Method      string
+	Path        string
+	RequestHash string              // hash of the request (body, query, format), a retry has to send the same one
+	Status      int                 // 0 while the first request is still being handled
+	Headers     map[string][]string // response headers to replay
+	Body        []byte              // response body to replay
+	ExpiresAt   time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Reserve claims the key for a new request. If it was claimed before (and hasn't expired)
+// nothing is written and the existing record is returned instead so the caller can replay it
+func (m IdempotencyModel) Reserve(record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// an expired key is free to be used again
+	_, err := m.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key = $1 AND method = $2 AND path = $3 AND expires_at <= NOW()
+		`, record.Key, record.Method, record.Path)
+	if err != nil {
+		return nil, fmt.Errorf("clearing expired idempotency key: %w", err)
+	}
+
+	result, err := m.DB.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (idempotency_key, method, path, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (idempotency_key, method, path) DO NOTHING
+		`, record.Key, record.Method, record.Path, record.RequestHash, record.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("reserving idempotency key: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("checking affected rows: %w", err)
+	}
+	if inserted == 1 {
+		return nil, nil
+	}
+
+	existing := IdempotencyRecord{Key: record.Key, Method: record.Method, Path: record.Path}
+	var status sql.NullInt32
+	var headers []byte
+	err = m.DB.QueryRowContext(ctx, `
+		SELECT request_hash, status, headers, body, expires_at
+		FROM idempotency_keys
+		WHERE idempotency_key = $1 AND method = $2 AND path = $3
+		`, record.Key, record.Method, record.Path).Scan(
+		&existing.RequestHash,
+		&status,
+		&headers,
+		&existing.Body,
+		&existing.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		// the other request gave the key back in between, let the client retry
+		case errors.Is(err, sql.ErrNoRows):
+			return &IdempotencyRecord{Key: record.Key, RequestHash: record.RequestHash}, nil
+		default:
+			return nil, fmt.Errorf("getting idempotency key: %w", err)
+		}
+	}
+	existing.Status = int(status.Int32)
+	err = json.Unmarshal(headers, &existing.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("decoding idempotency headers: %w", err)
+	}
+	return &existing, nil
+}
+
+// Complete stores the response for a reserved key so retries get the same answer
+func (m IdempotencyModel) Complete(record *IdempotencyRecord) error {
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return fmt.Errorf("encoding idempotency headers: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $4, headers = $5, body = $6
+		WHERE idempotency_key = $1 AND method = $2 AND path = $3
+		`, record.Key, record.Method, record.Path, record.Status, headers, record.Body)
+	if err != nil {
+		return fmt.Errorf("completing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Release gives back a key whose request failed so the client can retry it for real
+func (m IdempotencyModel) Release(record *IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key = $1 AND method = $2 AND path = $3 AND status IS NULL
+		`, record.Key, record.Method, record.Path)
+	if err != nil {
+		return fmt.Errorf("releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes every key past its TTL and returns how many were removed
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*defaultTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ReynerioSamos/reviews/internal/fingerprint"
+	"github.com/ReynerioSamos/reviews/internal/language"
+	"github.com/lib/pq"
+)
+
+// bulk loading for cmd/importer, rows go in with COPY instead of one INSERT each
+// everything runs in one transaction so a failed import leaves nothing behind
+// imported reviews skip the duplicate and review bombing checks, they're historical data
+type ImportModel struct {
+	DB *sql.DB
+}
+
+type Import struct {
+	tx       *sql.Tx
+	products map[int64]bool // products that got reviews, avg_rating is recalculated for them on Commit
+}
+
+func (m ImportModel) Begin(ctx context.Context) (*Import, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &Import{tx: tx, products: map[int64]bool{}}, nil
+}
+
+// CopyProducts loads the products and sets their PID
+// the ids are taken from the sequence first since COPY can't return them
+func (im *Import) CopyProducts(ctx context.Context, products []*Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	rows, err := im.tx.QueryContext(ctx,
+		`SELECT nextval(pg_get_serial_sequence('product', 'pid')) FROM generate_series(1, $1)`,
+		len(products))
+	if err != nil {
+		return fmt.Errorf("reserving product ids: %w", err)
+	}
+	for i := 0; rows.Next(); i++ {
+		err = rows.Scan(&products[i].PID)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning product id: %w", err)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := im.tx.PrepareContext(ctx, pq.CopyIn("product", "pid", "pname", "product_category", "image_url"))
+	if err != nil {
+		return fmt.Errorf("starting product copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, product := range products {
+		_, err = stmt.ExecContext(ctx, product.PID, product.Pname, product.Product_Category, product.Image_URL)
+		if err != nil {
+			return fmt.Errorf("copying product: %w", err)
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("copying products: %w", err)
+	}
+	return nil
+}
+
+// ProductsExist reports which of the ids are products that can be reviewed
+// products created earlier in the same import are included
+func (im *Import) ProductsExist(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	rows, err := im.tx.QueryContext(ctx,
+		`SELECT pid FROM product WHERE pid = ANY($1) AND deleted_at IS NULL`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("checking products: %w", err)
+	}
+	defer rows.Close()
+
+	exists := map[int64]bool{}
+	for rows.Next() {
+		var pid int64
+		err = rows.Scan(&pid)
+		if err != nil {
+			return nil, fmt.Errorf("scanning product id: %w", err)
+		}
+		exists[pid] = true
+	}
+	return exists, rows.Err()
+}
+
+// CopyReviews loads the reviews as published with the same sentiment, language and
+// fingerprint Insert would give them, verified_purchase and avg_rating are worked out on Commit
+func (im *Import) CopyReviews(ctx context.Context, reviews []*Review) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+
+	stmt, err := im.tx.PrepareContext(ctx, pq.CopyIn("review",
+		"prod_id", "rating", "body", "pros", "cons", "author",
+		"fingerprint", "fingerprint_bands", "status",
+		"sentiment_score", "sentiment", "sentiment_mismatch", "language", "search_config"))
+	if err != nil {
+		return fmt.Errorf("starting review copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, review := range reviews {
+		var fpValue any
+		bands := []int64{}
+		if fp, ok := reviewFingerprint(review); ok {
+			fpValue, bands = int64(fp), fingerprint.Bands(fp)
+		}
+		score, label, mismatch := reviewSentiment(review)
+		lang := reviewLanguage(review)
+
+		_, err = stmt.ExecContext(ctx,
+			review.Prod_ID,
+			review.Rating,
+			review.Body,
+			pq.Array(nonNil(review.Pros)),
+			pq.Array(nonNil(review.Cons)),
+			review.Author,
+			fpValue,
+			pq.Array(bands),
+			ReviewPublished,
+			score,
+			label,
+			mismatch,
+			lang,
+			language.SearchConfig(lang),
+		)
+		if err != nil {
+			return fmt.Errorf("copying review: %w", err)
+		}
+		im.products[review.Prod_ID] = true
+	}
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("copying reviews: %w", err)
+	}
+	return nil
+}
+
+// Commit marks the imported reviews whose authors bought the product as verified,
+// recalculates avg_rating for every product that got reviews and commits the import
+// the author is enough here (no review token), the files come from our own exports and are
+// imported by an operator
+func (im *Import) Commit(ctx context.Context) error {
+	defer im.tx.Rollback()
+
+	if len(im.products) > 0 {
+		ids := make([]int64, 0, len(im.products))
+		for pid := range im.products {
+			ids = append(ids, pid)
+		}
+
+		_, err := im.tx.ExecContext(ctx, `
+			UPDATE review r
+			SET verified_purchase = TRUE
+			WHERE r.prod_id = ANY($1)
+			AND r.author <> '' AND NOT r.verified_purchase
+			AND EXISTS (
+				SELECT 1
+				FROM purchase pu
+				WHERE pu.customer = r.author
+				AND pu.prod_id = r.prod_id
+				AND pu.purchased_at <= r.created_at
+			)`, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("verifying imported reviews: %w", err)
+		}
+
+		for _, pid := range ids {
+			err = updateAvgRating(ctx, im.tx, pid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := im.tx.Commit(); err != nil {
+		return fmt.Errorf("committing import: %w", err)
+	}
+	return nil
+}
+
+// Rollback throws the whole import away
+func (im *Import) Rollback() error {
+	return im.tx.Rollback()
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ReynerioSamos/reviews/internal/validator"
+)
+
+// moderators can only publish or reject a held review
+func ValidateModerationStatus(v *validator.Validator, status string) {
+	v.Check(validator.PermittedValue(status, ReviewPublished, ReviewRejected), "status", "must be published or rejected")
+}
+
+// GetPending lists the reviews waiting for a moderator, oldest first
+func (r ReviewModel) GetPending(filters Filters) ([]*Review, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), ` + reviewColumns + `
+		FROM review r
+		JOIN product p ON p.pid = r.prod_id
+		WHERE r.status = 'pending'
+		AND r.deleted_at IS NULL AND p.deleted_at IS NULL
+		ORDER BY r.created_at ASC, r.rid ASC
+		LIMIT $1 OFFSET $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("querying pending reviews: %w", err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+		err := scanReview(rows, &review, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, fmt.Errorf("scanning pending review row: %w", err)
+		}
+		reviews = append(reviews, &review)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// SetStatus publishes or rejects a review, the product's avg_rating follows
+// since only published reviews are counted in it
+// subscribers see a review.created when it gets published and a review.deleted when a
+// published one is rejected, nothing is sent for a review they never saw
+func (r ReviewModel) SetStatus(id int64, status string) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM review WHERE rid = $1 AND deleted_at IS NULL FOR UPDATE`, id).
+		Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("reading review status: %w", err)
+		}
+	}
+
+	query := `
+        WITH moderated_review AS (
+            UPDATE review
+            SET status = $2
+            WHERE rid = $1 AND deleted_at IS NULL
+            RETURNING *
+        )
+        SELECT ` + reviewColumns + `
+        FROM moderated_review r
+        JOIN product p ON p.pid = r.prod_id
+    `
+	var review Review
+	err = scanReview(tx.QueryRowContext(ctx, query, id, status), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("moderating review: %w", err)
+		}
+	}
+
+	rating, err := ratingChange(ctx, tx, review.Prod_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	switch {
+	case previous != ReviewPublished && review.Status == ReviewPublished:
+		events = reviewEvents(EventReviewCreated, &review)
+	case previous == ReviewPublished && review.Status != ReviewPublished:
+		events = []Event{newEvent(EventReviewDeleted, DeletedReview{RID: review.RID, Prod_ID: review.Prod_ID})}
+	}
+
+	err = writeOutbox(ctx, tx, withRatingChange(events, rating))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return &review, nil
+}
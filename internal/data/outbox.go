@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// an event waiting in the outbox to be published, Payload is the Event as JSON
+type OutboxEvent struct {
+	ID         int64
+	Event_ID   string
+	Event_Type string
+	Payload    json.RawMessage
+	Attempts   int // failed attempts so far
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// writes the events of a change inside its transaction, they're only published once it commits
+func writeOutbox(ctx context.Context, tx *sql.Tx, events []Event) error {
+	query := `
+		INSERT INTO outbox (event_id, event_type, payload)
+		VALUES ($1, $2, $3::jsonb)
+		`
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("encoding event: %w", err)
+		}
+		// as a string, a []byte would be sent as bytea
+		_, err = tx.ExecContext(ctx, query, event.ID, event.Type, string(payload))
+		if err != nil {
+			return fmt.Errorf("writing event to the outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// Claim takes up to limit unpublished events that are due, oldest first
+// they're pushed back by lease so another relay (or this one after a crash) only picks them up
+// again if the outcome never got recorded, that's where at-least-once comes from
+func (m OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM outbox
+			WHERE published_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox o
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due
+		WHERE o.id = due.id
+		RETURNING o.id, o.event_id, o.event_type, o.payload, o.attempts
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claiming outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		var event OutboxEvent
+		err := rows.Scan(&event.ID, &event.Event_ID, &event.Event_Type, &event.Payload, &event.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("scanning outbox row: %w", err)
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+// MarkPublished records that every sink has the event
+func (m OutboxModel) MarkPublished(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+		UPDATE outbox
+		SET published_at = NOW(), last_error = ''
+		WHERE id = $1
+		`, id)
+	if err != nil {
+		return fmt.Errorf("marking outbox event published: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed keeps the event for another try at retryAt, it's never given up on
+func (m OutboxModel) MarkFailed(id int64, reason string, retryAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+		`, id, reason, retryAt)
+	if err != nil {
+		return fmt.Errorf("recording outbox failure: %w", err)
+	}
+	return nil
+}
+
+// Purge removes the events that were published before the cutoff
+func (m OutboxModel) Purge(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*defaultTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `
+		DELETE FROM outbox
+		WHERE published_at IS NOT NULL AND published_at < $1
+		`, before)
+	if err != nil {
+		return 0, fmt.Errorf("purging outbox: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -17,19 +17,21 @@ type Product struct {
 	Product_Category string    `json:"product_category"` // category of the product
 	Image_URL        string    `json:"image_url"`        // string containing URL for image for product
 	Avg_Rating       float32   `json:"avg_rating"`       // avg_rating of product, updates on review creation, deletion and updates
+	Version          int32     `json:"version"`          // incremented on every update, used to detect edit conflicts
 	CreatedAt        time.Time `json:"-"`                // database timestamp
+	UpdatedAt        time.Time `json:"-"`                // last time anything on the row changed, used for ETag and Last-Modified
 
 }
 
 func ValidateProduct(v *validator.Validator, product *Product) {
 	//string.TrimSpace() is used to treat long spaces as empty as well (____ vs _)
 	// check if product name field is empty
-	v.Check(strings.TrimSpace(product.Pname) != "", "Product Name", "must be provided")
+	v.Check(strings.TrimSpace(product.Pname) != "", "pname", "must be provided")
 	// check if product category field is empty
-	v.Check(strings.TrimSpace(product.Product_Category) != "", "Product Category", "must be provided")
+	v.Check(strings.TrimSpace(product.Product_Category) != "", "product_category", "must be provided")
 
 	// check if the product name field is too long
-	v.Check(len(product.Pname) <= 255, "Product Name", "must not be more than 255 bytes long")
+	v.Check(len(product.Pname) <= 255, "pname", "must not be more than 255 bytes long")
 
 }
 
@@ -44,48 +46,105 @@ const (
 
 // Insert/Create Functionality
 func (p ProductModel) Insert(product *Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = insertProduct(ctx, tx, product)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutbox(ctx, tx, []Event{newEvent(EventProductCreated, product)})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// the insert itself, shared with SaveBatch which runs it inside a transaction
+func insertProduct(ctx context.Context, q dbtx, product *Product) error {
 	// the SQL query to be executed against the database table
 	query := `
 		INSERT INTO product (pname, product_category, image_URL)
 		VALUES ($1, $2, $3)
-		RETURNING pid, created_at
+		RETURNING pid, created_at, updated_at, version
 		`
 	// the actual values to replace $1, and $2
 	args := []any{product.Pname, product.Product_Category, product.Image_URL}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.PID, &product.CreatedAt)
+	return q.QueryRowContext(ctx, query, args...).Scan(&product.PID, &product.CreatedAt, &product.UpdatedAt, &product.Version)
 }
 
 // Get/Read Functionality
 // Get a specific product from the products table
 func (p ProductModel) Get(id int64) (*Product, error) {
+	return p.GetFields(id, nil)
+}
+
+// the fields a client can pick with ?fields=
+var ProductFieldSafeList = []string{"pid", "pname", "product_category", "image_url", "avg_rating", "version"}
+
+// the ?sort= values for product lists, REST, GraphQL and gRPC all use this one
+var ProductSortSafeList = []string{"pid", "pname", "product_category", "avg_rating",
+	"-pid", "-pname", "-product_category", "-avg_rating"}
+
+// the SELECT list and the Scan destinations for the picked fields, every field when fields is empty
+// pid, version and updated_at are always read since the ETag and the includes need them
+func productSelect(product *Product, fields []string) (string, []any) {
+	columns := []string{"pid", "version", "updated_at"}
+	dest := []any{&product.PID, &product.Version, &product.UpdatedAt}
+	if len(fields) == 0 {
+		fields = ProductFieldSafeList
+		columns, dest = append(columns, "created_at"), append(dest, &product.CreatedAt)
+	}
+	for _, field := range fields {
+		switch field {
+		case "pname":
+			columns, dest = append(columns, "pname"), append(dest, &product.Pname)
+		case "product_category":
+			columns, dest = append(columns, "product_category"), append(dest, &product.Product_Category)
+		case "image_url":
+			columns, dest = append(columns, "image_URL"), append(dest, &product.Image_URL)
+		case "avg_rating":
+			columns, dest = append(columns, "avg_rating"), append(dest, &product.Avg_Rating)
+		}
+	}
+	return strings.Join(columns, ", "), dest
+}
+
+// GetFields is Get reading only the picked columns (see ProductFieldSafeList), the other
+// fields of the product are left at their zero value
+func (p ProductModel) GetFields(id int64, fields []string) (*Product, error) {
 	// check if the id is valid
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	// declare a variable of type product to store the returned product
+	var product Product
+	columns, dest := productSelect(&product, fields)
+
 	// the SQL query to be executed against the database table
 	query := `
-		SELECT pid, created_at, pname, product_category, image_URL, avg_rating
+		SELECT ` + columns + `
 		FROM product
-		WHERE pid = $1
+		WHERE pid = $1 AND deleted_at IS NULL
 		`
-	// declare a variable of type product to store the returned product
-	var product Product
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, id).Scan(
-		&product.PID,
-		&product.CreatedAt,
-		&product.Pname,
-		&product.Product_Category,
-		&product.Image_URL,
-		&product.Avg_Rating,
-	)
+	err := p.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 
 	if err != nil {
 		switch {
@@ -99,26 +158,53 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 }
 
 // Post/Update Functionality
+// the update only goes through if nobody else changed the product since it was read
+// (same version), otherwise ErrEditConflict is returned and nothing is written
 func (p ProductModel) Update(product *Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*defaultTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = updateProduct(ctx, tx, product)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutbox(ctx, tx, []Event{newEvent(EventProductUpdated, product)})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// the update itself, shared with SaveBatch which runs it inside a transaction
+func updateProduct(ctx context.Context, q dbtx, product *Product) error {
 	// The SQL query to be executed against the database table
 	query := `
 		UPDATE product
-		SET pname = $1, product_category = $2, image_url = $3
-		WHERE pid = $4
-		RETURNING pname, product_category
+		SET pname = $1, product_category = $2, image_url = $3, version = version + 1
+		WHERE pid = $4 AND version = $5 AND deleted_at IS NULL
+		RETURNING pname, product_category, version, updated_at
 		`
 
-	args := []any{product.Pname, product.Product_Category, product.Image_URL, product.PID}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*defaultTimeout)
-	defer cancel()
+	args := []any{product.Pname, product.Product_Category, product.Image_URL, product.PID, product.Version}
 
-	result := p.DB.QueryRowContext(ctx, query, args...)
+	result := q.QueryRowContext(ctx, query, args...)
 
-	err := result.Scan(&product.Pname, &product.Product_Category)
+	err := result.Scan(&product.Pname, &product.Product_Category, &product.Version, &product.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return fmt.Errorf("updating product: %w", err)
 		}
@@ -127,6 +213,8 @@ func (p ProductModel) Update(product *Product) error {
 }
 
 // Cut/Delete Functionaity
+// products are soft deleted, a hard delete would cascade and wipe every review too
+// the row (and its reviews) stay hidden until the purge command removes them
 
 func (p ProductModel) Delete(id int64) error {
 	// check if the id is valid
@@ -135,16 +223,23 @@ func (p ProductModel) Delete(id int64) error {
 	}
 	// the SQL query to be executied against the database table
 	query := `
-		DELETE FROM product
-		WHERE pid = $1	
+		UPDATE product
+		SET deleted_at = NOW()
+		WHERE pid = $1 AND deleted_at IS NULL
 		`
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// ExecContext does not return any rows unlike QueryRowContext.
 	// It only returns information about the query execution
 
-	result, err := p.DB.ExecContext(ctx, query, id)
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("deleting product: %w", err)
 	}
@@ -160,36 +255,121 @@ func (p ProductModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
+	err = writeOutbox(ctx, tx, []Event{newEvent(EventProductDeleted, DeletedProduct{PID: id})})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
 	return nil
 }
 
-// Get all products
-// runs the query, and checked the query manually to see if any results show and they do
-// some issue with either the handler or how the product[] array or metadata type
-// populate
-func (p ProductModel) GetAll(pname string, product_category string, avg_rating float32, filters Filters) ([]*Product, Metadata, error) {
+// Restore undoes a soft delete, the product's reviews come back with it
+func (p ProductModel) Restore(id int64) (*Product, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
 
-	//Log input params for diagnostics
-	fmt.Printf("GetAll: pname=%s, product_category=%s, avg_rating=%.2f, filters=%+v\n", pname, product_category, avg_rating, filters)
+	query := `
+		UPDATE product
+		SET deleted_at = NULL
+		WHERE pid = $1 AND deleted_at IS NOT NULL
+		RETURNING pid, created_at, pname, product_category, image_URL, avg_rating, version, updated_at
+		`
+	var product Product
 
-	// Format the avg_rating to text for tsquery compatibility
-	avgRatingStr := fmt.Sprintf("%.2f", avg_rating)
-	// It's then compared using CAST() from postgresql to better match corresponding decimals using LIKE
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&product.PID,
+		&product.CreatedAt,
+		&product.Pname,
+		&product.Product_Category,
+		&product.Image_URL,
+		&product.Avg_Rating,
+		&product.Version,
+		&product.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("restoring product: %w", err)
+		}
+	}
+
+	err = writeOutbox(ctx, tx, []Event{newEvent(EventProductRestored, &product)})
+	if err != nil {
+		return nil, err
+	}
 
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return &product, nil
+}
+
+// Purge permanently removes products that were soft deleted before the cutoff
+// ON DELETE CASCADE takes their reviews with them
+func (p ProductModel) Purge(before time.Time) (int64, error) {
 	query := `
-		SELECT COUNT(*) OVER(), pid, created_at, pname, product_category, image_URL, avg_rating
-		FROM product
-		WHERE (to_tsvector('simple', pname) @@
+		DELETE FROM product
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*defaultTimeout)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("purging products: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// the filters GetAll and Export share, $1 name, $2 category and $3 avg_rating (as text)
+const productListFilters = `deleted_at IS NULL
+		AND (to_tsvector('simple', pname) @@
 				plainto_tsquery('simple', $1) OR $1 = '')
 		AND (to_tsvector('simple', product_category) @@
 				plainto_tsquery('simple', $2) OR $2 = '')
-		AND (CAST(avg_rating AS TEXT) LIKE $3 OR $3 = '')
-		ORDER BY pid
+		AND (CAST(avg_rating AS TEXT) LIKE $3 OR $3 = '')`
+
+// avg_rating as the text productListFilters compares against, 0 (not given) means no filter
+// instead of only matching products rated 0.00
+func avgRatingFilter(avg_rating float32) string {
+	if avg_rating == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", avg_rating)
+}
+
+// Get all products
+// fields picks the columns like GetFields does, pid breaks ties in the requested sort
+func (p ProductModel) GetAll(pname string, product_category string, avg_rating float32, fields []string, filters Filters) ([]*Product, Metadata, error) {
+	// Format the avg_rating to text for tsquery compatibility
+	avgRatingStr := avgRatingFilter(avg_rating)
+	// It's then compared using CAST() from postgresql to better match corresponding decimals using LIKE
+
+	columns, _ := productSelect(&Product{}, fields)
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), `+columns+`
+		FROM product
+		WHERE `+productListFilters+`
+		ORDER BY %s %s, pid ASC
 		LIMIT $4 OFFSET $5
-		`
+		`, filters.sortColumn(), filters.sortDirection())
 
-	// log generated SQL query
-	fmt.Printf("GetAll: SQL query=%s\n", query)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
@@ -209,14 +389,9 @@ func (p ProductModel) GetAll(pname string, product_category string, avg_rating f
 	// process each row that is in the var rows
 	for rows.Next() {
 		var product Product
-		err := rows.Scan(
-			&totalRecords, // window function result
-			&product.PID,
-			&product.CreatedAt,
-			&product.Pname,
-			&product.Product_Category,
-			&product.Image_URL,
-			&product.Avg_Rating)
+		_, dest := productSelect(&product, fields)
+		// window function result first
+		err := rows.Scan(append([]any{&totalRecords}, dest...)...)
 
 		if err != nil {
 			return nil, Metadata{}, fmt.Errorf("scanning product row: %w", err)
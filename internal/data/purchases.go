@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/lib/pq"
+)
+
+// max number of purchases accepted in a single ingestion batch
+const MaxPurchaseBatch = 1000
+
+type Purchase struct {
+	Order_ID     string    `json:"order_id"`     // id of the order on the storefront
+	Customer     string    `json:"customer"`     // customer identifier, matches Review.Author
+	Prod_ID      int64     `json:"prod_id"`      // product that was bought
+	Variant      string    `json:"variant"`      // optional variant (size, colour...) of the product
+	Purchased_At time.Time `json:"purchased_at"` // when the order was placed
+	Review_Token string    `json:"review_token"` // handed to the customer with the order, proves the purchase when reviewing
+}
+
+type PurchaseModel struct {
+	DB *sql.DB
+}
+
+// key is prefixed with the position in the batch so the client knows which item is wrong
+func ValidatePurchase(v *validator.Validator, purchase *Purchase, key string) {
+	v.Check(strings.TrimSpace(purchase.Order_ID) != "", key+"order_id", "must be provided")
+	v.Check(strings.TrimSpace(purchase.Customer) != "", key+"customer", "must be provided")
+	v.Check(len(purchase.Customer) <= 255, key+"customer", "must not be more than 255 bytes long")
+	v.Check(purchase.Prod_ID > 0, key+"prod_id", "must be valid")
+	v.Check(!purchase.Purchased_At.IsZero(), key+"purchased_at", "must be provided")
+	v.Check(purchase.Purchased_At.Before(time.Now().Add(time.Minute)), key+"purchased_at", "must not be in the future")
+	// without a token the purchase can't verify any review
+	if purchase.Review_Token != "" {
+		v.Check(len(purchase.Review_Token) >= 16, key+"review_token", "must be at least 16 bytes long")
+		v.Check(len(purchase.Review_Token) <= 255, key+"review_token", "must not be more than 255 bytes long")
+	}
+}
+
+// only the hash of a review token is stored, empty for no token (stored as NULL)
+func tokenHash(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// the condition for the verified purchase badge: the review brought the review token of a
+// purchase of the product (of the reviewed variant when there is one) by its author, made before
+// the review was written
+// the arguments are the SQL for the review's values, e.g. r.purchase_token_hash or $1
+func verifiedPurchase(tokenHash, author, prodID, variant, writtenAt string) string {
+	return fmt.Sprintf(`%[1]s IS NOT NULL AND EXISTS (
+				SELECT 1
+				FROM purchase pu
+				WHERE pu.review_token_hash = %[1]s
+				AND pu.customer = %[2]s
+				AND pu.prod_id = %[3]s
+				AND (%[4]s = '' OR pu.variant = %[4]s)
+				AND pu.purchased_at <= %[5]s
+			)`, tokenHash, author, prodID, variant, writtenAt)
+}
+
+// InsertBatch stores the purchases and marks the reviews that were written with their review
+// tokens as verified. Purchases that were already ingested are skipped
+// so the storefront can safely resend a batch, so are purchases of products we don't list
+func (p PurchaseModel) InsertBatch(purchases []*Purchase) (inserted int, verified int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*defaultTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+		INSERT INTO purchase (order_id, customer, prod_id, variant, purchased_at, review_token_hash)
+		SELECT $1, $2, $3, $4, $5, NULLIF($6, '')
+		WHERE EXISTS (SELECT 1 FROM product WHERE pid = $3)
+		ON CONFLICT (order_id, prod_id, variant) DO NOTHING
+		`
+	stmt, err := tx.PrepareContext(ctx, insertQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("preparing purchase insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, purchase := range purchases {
+		result, err := stmt.ExecContext(ctx,
+			purchase.Order_ID,
+			purchase.Customer,
+			purchase.Prod_ID,
+			purchase.Variant,
+			purchase.Purchased_At,
+			tokenHash(purchase.Review_Token))
+		if err != nil {
+			return 0, 0, fmt.Errorf("inserting purchase %s: %w", purchase.Order_ID, err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, 0, fmt.Errorf("checking affected rows: %w", err)
+		}
+		inserted += int(rows)
+	}
+
+	// orders usually show up after the review was written so the reviews
+	// that are already in the table have to be checked again
+	verifyQuery := `
+		UPDATE review r
+		SET verified_purchase = true
+		WHERE r.verified_purchase = false
+		AND r.purchase_token_hash = ANY($1)
+		AND ` + verifiedPurchase("r.purchase_token_hash", "r.author", "r.prod_id", "r.variant", "r.created_at")
+	hashes := []string{}
+	for _, purchase := range purchases {
+		if hash := tokenHash(purchase.Review_Token); hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	result, err := tx.ExecContext(ctx, verifyQuery, pq.Array(hashes))
+	if err != nil {
+		return 0, 0, fmt.Errorf("verifying reviews: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("checking affected rows: %w", err)
+	}
+	verified = int(rows)
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return inserted, verified, nil
+}
@@ -6,47 +6,145 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/ReynerioSamos/reviews/internal/anomaly"
+	"github.com/ReynerioSamos/reviews/internal/fingerprint"
+	"github.com/ReynerioSamos/reviews/internal/language"
+	"github.com/ReynerioSamos/reviews/internal/normalize"
+	"github.com/ReynerioSamos/reviews/internal/sentiment"
 	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	minRating = 1
 	maxRating = 5
 	// defaultTimeout = 3*time.seconds
+
+	// limits for the pros and cons lists
+	maxListEntries = 10
+	maxEntryLength = 100
+	// limit for the written review
+	maxBodyLength = 5000
+)
+
+// moderation states of a review, only published reviews are listed and counted in avg_rating
+const (
+	ReviewPublished = "published"
+	ReviewPending   = "pending"
+	ReviewRejected  = "rejected"
+)
+
+// what Insert does when it finds a (near) duplicate of the new review
+const (
+	DuplicateReject = "reject" // refuse the review with a DuplicateReviewError
+	DuplicateFlag   = "flag"   // insert it as pending so a moderator can look at it
 )
 
 type Review struct {
-	RID           int64     `json:"rid"`                    // unique value for each product
-	Prod_ID       int64     `json:"prod_id"`                // associated product ID
-	Rating        int8      `json:"rating"`                 // rating field from 1-5
-	Helpful_Count int       `json:"helpful_count"`          // helpful_count integer
-	CreatedAt     time.Time `json:"-"`                      // database timestamp
-	ProductName   string    `json:"product_name,omitempty"` // additional field to help with joins
+	RID           int64      `json:"rid"`                       // unique value for each product
+	Prod_ID       int64      `json:"prod_id"`                   // associated product ID
+	Rating        int8       `json:"rating"`                    // rating field from 1-5
+	Helpful_Count int        `json:"helpful_count"`             // helpful_count integer
+	Body          string     `json:"body"`                      // the written review
+	Pros          []string   `json:"pros"`                      // short things the reviewer liked
+	Cons          []string   `json:"cons"`                      // short things the reviewer didn't like
+	Author        string     `json:"author,omitempty"`          // customer identifier of whoever wrote the review
+	Variant       string     `json:"variant,omitempty"`         // variant of the product that was reviewed, empty for the product as a whole
+	Review_Token  string     `json:"-"`                         // review token of the author's purchase, only read when the review is created
+	Verified      bool       `json:"verified_purchase"`         // the author bought the product before reviewing it
+	Edited        bool       `json:"edited"`                    // the review was changed after it was posted
+	EditedAt      *time.Time `json:"edited_at,omitempty"`       // when it was last changed, nil if never
+	Status        string     `json:"status"`                    // published, pending or rejected
+	Flag_Reason   string     `json:"flag_reason,omitempty"`     // why the review was held for moderation
+	Duplicate_Of  *int64     `json:"duplicate_of,omitempty"`    // rid of the review this one is a copy of
+	Sentiment     string     `json:"sentiment,omitempty"`       // positive, neutral or negative, empty without a body
+	Score         *float64   `json:"sentiment_score,omitempty"` // -1 to +1, nil without a body
+	Mismatch      bool       `json:"sentiment_mismatch"`        // the rating and the text disagree
+	Language      string     `json:"language,omitempty"`        // ISO 639-1 code of the text, empty when unknown
+	Version       int32      `json:"version"`                   // incremented on every edit, used to detect edit conflicts
+	CreatedAt     time.Time  `json:"-"`                         // database timestamp
+	UpdatedAt     time.Time  `json:"-"`                         // last time anything on the row changed, used for ETag and Last-Modified
+	ProductName   string     `json:"product_name,omitempty"`    // additional field to help with joins
 }
 
 type ReviewModel struct {
-	DB *sql.DB
+	DB              *sql.DB
+	DuplicateAction string           // DuplicateReject or DuplicateFlag, flag when empty
+	Detector        anomaly.Detector // review bombing detector, disabled when MinReviews is 0
+}
+
+// returned by Insert when the review is a (near) duplicate and DuplicateAction is reject
+type DuplicateReviewError struct {
+	DuplicateOf int64 // the review that was matched
+	SameAuthor  bool  // the author posted the matched review too
+}
+
+func (e *DuplicateReviewError) Error() string {
+	return fmt.Sprintf("review is a duplicate of review %d", e.DuplicateOf)
+}
+
+// columns every review query selects (r = review, p = product), in the order scanReview expects them
+const reviewColumns = `
+			r.rid, r.created_at, r.prod_id, p.pname, r.rating, r.helpful_count,
+			r.body, r.pros, r.cons, r.author, r.variant, r.verified_purchase, r.edited_at,
+			r.status, r.flag_reason, r.duplicate_of,
+			r.sentiment, r.sentiment_score, r.sentiment_mismatch, r.language,
+			r.version, r.updated_at`
+
+// *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scans reviewColumns into the review, leading is for anything selected before them e.g. COUNT(*) OVER()
+func scanReview(row rowScanner, review *Review, leading ...any) error {
+	dest := append(leading,
+		&review.RID,
+		&review.CreatedAt,
+		&review.Prod_ID,
+		&review.ProductName,
+		&review.Rating,
+		&review.Helpful_Count,
+		&review.Body,
+		pq.Array(&review.Pros),
+		pq.Array(&review.Cons),
+		&review.Author,
+		&review.Variant,
+		&review.Verified,
+		&review.EditedAt,
+		&review.Status,
+		&review.Flag_Reason,
+		&review.Duplicate_Of,
+		&review.Sentiment,
+		&review.Score,
+		&review.Mismatch,
+		&review.Language,
+		&review.Version,
+		&review.UpdatedAt,
+	)
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	review.Edited = review.EditedAt != nil
+	return nil
 }
 
 func ValidateReview(v *validator.Validator, review *Review, vdb ReviewModel) {
 	log.Printf("Validating review for product ID: %d", review.Prod_ID)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
-	// Check if ProdID is positive
-	v.Check(review.Prod_ID > 0, "Prod_ID:", "must be valid")
-	// Check if Rating is valid
-	v.Check(review.Rating >= minRating && review.Rating <= maxRating, "Rating:", "must be between 1 and 5")
-	// Empty values check validators
-	v.Check(review.Prod_ID != 0, "Prod_ID:", "must be provided")
-	v.Check(review.Rating != 0, "Rating:", "must be prodivded")
+	ValidateReviewFields(v, review)
 
 	// Check if product exists using a prepared statement
 	log.Printf("Checking product existance in database...")
 	var exists bool
 	err := vdb.DB.QueryRowContext(ctx,
-		`SELECT EXISTS(SELECT 1 FROM product WHERE pid = $1)`,
+		`SELECT EXISTS(SELECT 1 FROM product WHERE pid = $1 AND deleted_at IS NULL)`,
 		review.Prod_ID).Scan(&exists)
 
 	if err != nil {
@@ -55,7 +153,36 @@ func ValidateReview(v *validator.Validator, review *Review, vdb ReviewModel) {
 		return
 	}
 	if !exists {
-		v.AddError("Prod_ID:", "referenced prodcut does not exist")
+		v.AddError("prod_id", "referenced prodcut does not exist")
+	}
+}
+
+// the checks of ValidateReview that don't need the database
+// the importer uses this on its own and checks the products in bulk
+func ValidateReviewFields(v *validator.Validator, review *Review) {
+	// Check if ProdID is positive
+	v.Check(review.Prod_ID > 0, "prod_id", "must be valid")
+	// Check if Rating is valid
+	v.Check(review.Rating >= minRating && review.Rating <= maxRating, "rating", "must be between 1 and 5")
+	// Empty values check validators
+	v.Check(review.Prod_ID != 0, "prod_id", "must be provided")
+	v.Check(review.Rating != 0, "rating", "must be prodivded")
+	// pros and cons are optional but each entry has to be a short non-empty phrase
+	validateReviewList(v, review.Pros, "pros")
+	validateReviewList(v, review.Cons, "cons")
+	v.Check(len(review.Author) <= 255, "author", "must not be more than 255 bytes long")
+	v.Check(len(review.Variant) <= 255, "variant", "must not be more than 255 bytes long")
+	v.Check(len(review.Review_Token) <= 255, "review_token", "must not be more than 255 bytes long")
+	v.Check(review.Review_Token == "" || review.Author != "", "author", "must be provided with a review token")
+	v.Check(len(review.Body) <= maxBodyLength, "body", fmt.Sprintf("must not be more than %d bytes long", maxBodyLength))
+}
+
+// checks a pros or cons list
+func validateReviewList(v *validator.Validator, list []string, key string) {
+	v.Check(len(list) <= maxListEntries, key, fmt.Sprintf("must not contain more than %d entries", maxListEntries))
+	for _, entry := range list {
+		v.Check(strings.TrimSpace(entry) != "", key, "must not contain empty entries")
+		v.Check(len(entry) <= maxEntryLength, key, fmt.Sprintf("entries must not be more than %d bytes long", maxEntryLength))
 	}
 }
 
@@ -70,62 +197,131 @@ func (r ReviewModel) Insert(review *Review) error {
 	}
 	defer tx.Rollback() // Rollback if we don't commit
 
-	// Insert the review and update product's avg_rating in a single query
+	err = r.insert(ctx, tx, review)
+	if err != nil {
+		return err
+	}
+
+	rating, err := ratingChange(ctx, tx, review.Prod_ID)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutbox(ctx, tx, withRatingChange(reviewEvents(EventReviewCreated, review), rating))
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// the insert itself without the avg_rating recalculation, shared with SaveBatch
+// which recalculates it once per product at the end of the batch
+func (r ReviewModel) insert(ctx context.Context, tx *sql.Tx, review *Review) error {
+	// look for copies of this review from the same author or anywhere in the catalog
+	fp, hasFingerprint := reviewFingerprint(review)
+	status, flagReason := ReviewPublished, ""
+	var duplicateOf *int64
+	if hasFingerprint {
+		match, err := findDuplicate(ctx, tx, fp, review.Author)
+		if err != nil {
+			return err
+		}
+		if match != nil {
+			if r.DuplicateAction == DuplicateReject {
+				return match
+			}
+			status, flagReason, duplicateOf = ReviewPending, "duplicate", &match.DuplicateOf
+		}
+	}
+
+	// hold the review if the product is in the middle of a burst of reviews
+	if status == ReviewPublished {
+		kind, err := checkAnomaly(ctx, tx, r.Detector, review)
+		if err != nil {
+			return err
+		}
+		if kind != "" {
+			status, flagReason = ReviewPending, "anomaly: "+kind
+		}
+	}
+
+	var fpValue any
+	bands := []int64{}
+	if hasFingerprint {
+		fpValue, bands = int64(fp), fingerprint.Bands(fp)
+	}
+
+	score, label, mismatch := reviewSentiment(review)
+	lang := reviewLanguage(review)
+
+	// Insert the review, the product's avg_rating is recalculated right after
 	query := `
         WITH inserted_review AS (
-            INSERT INTO review (prod_id, rating, helpful_count)
-            VALUES ($1, $2, 0)
-            RETURNING rid, created_at, prod_id, rating, helpful_count
-        ),
-        update_avg AS (
-            UPDATE product p
-            SET avg_rating = (
-                SELECT ROUND(AVG(rating)::numeric, 2)
-                FROM review r
-                WHERE r.prod_id = p.pid
+            INSERT INTO review (prod_id, rating, helpful_count, pros, cons, author, variant,
+                purchase_token_hash, verified_purchase,
+                body, fingerprint, fingerprint_bands, status, flag_reason, duplicate_of,
+                sentiment_score, sentiment, sentiment_mismatch, language, search_config)
+            VALUES ($1, $2, 0, $3, $4, $5, $17, NULLIF($18, ''),
+                -- verified when the review brings the token of the author's purchase
+                ` + verifiedPurchase("NULLIF($18, '')", "$5", "$1", "$17", "NOW()") + `,
+                $6, $7, $8, $9, $10, $11,
+                $12, $13, $14, $15, $16::regconfig
             )
-            WHERE pid = $1
-            RETURNING pname
+            RETURNING *
         )
-        SELECT 
-            ir.rid,
-            ir.created_at,
-            ir.prod_id,
-			ua.pname,
-            ir.rating,
-			ir.helpful_count
-        FROM inserted_review ir
-        CROSS JOIN update_avg ua;
+        SELECT ` + reviewColumns + `
+        FROM inserted_review r
+        JOIN product p ON p.pid = r.prod_id;
     `
 
-	err = tx.QueryRowContext(
+	row := tx.QueryRowContext(
 		ctx,
 		query,
 		review.Prod_ID,
 		review.Rating,
-	).Scan(
-		&review.RID,
-		&review.CreatedAt,
-		&review.Prod_ID,
-		&review.ProductName,
-		&review.Rating,
-		&review.Helpful_Count,
+		pq.Array(nonNil(review.Pros)),
+		pq.Array(nonNil(review.Cons)),
+		review.Author,
+		review.Body,
+		fpValue,
+		pq.Array(bands),
+		status,
+		flagReason,
+		duplicateOf,
+		score,
+		label,
+		mismatch,
+		lang,
+		language.SearchConfig(lang),
+		review.Variant,
+		tokenHash(review.Review_Token),
 	)
+	err := scanReview(row, review)
 	if err != nil {
 		return fmt.Errorf("failed to insert review: %w", err)
 	}
-
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("committing transaction: %w", err)
-	}
-
 	return nil
 }
 
 // Get/Read Functionality
-// Get a specific review from the review table
+// Get a specific review from the review table, only published reviews are found
+// so the ones held for moderation or rejected stay hidden
 func (r ReviewModel) Get(id int64) (*Review, error) {
+	return r.get(id, true)
+}
+
+// GetAny is Get whatever the status of the review, for the edit and moderator paths
+func (r ReviewModel) GetAny(id int64) (*Review, error) {
+	return r.get(id, false)
+}
+
+func (r ReviewModel) get(id int64, publishedOnly bool) (*Review, error) {
 	// check if the id is valid
 	if id < 1 {
 		return nil, ErrRecordNotFound
@@ -133,10 +329,12 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 
 	// the SQL query to be executed against the database table
 	query := `
-		SELECT r.rid, r.created_at, r.prod_id, p.pname , r.rating, r.helpful_count
+		SELECT ` + reviewColumns + `
 		FROM review r
 		JOIN product p ON r.prod_id = p.pid
 		WHERE r.rid = $1
+		AND r.deleted_at IS NULL AND p.deleted_at IS NULL
+		AND (r.status = 'published' OR NOT $2)
 		`
 	// declare a variable of type review to store the returned review
 	var review Review
@@ -144,15 +342,7 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(
-		&review.RID,
-		&review.CreatedAt,
-		&review.Prod_ID,
-		&review.ProductName,
-		&review.Rating,
-		&review.Helpful_Count,
-	)
-
+	err := scanReview(r.DB.QueryRowContext(ctx, query, id, publishedOnly), &review)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -166,6 +356,8 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 
 // Post/Update Functionality
 // U - in CRUD also applies to the helpful_count attribute
+// review.Version has to be the version that was read, if the review changed since then
+// ErrEditConflict is returned and nothing (not even the revision) is written
 func (r ReviewModel) Update(review *Review) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -177,61 +369,121 @@ func (r ReviewModel) Update(review *Review) error {
 	}
 	defer tx.Rollback()
 
-	// Update review and product's avg_rating in a single query using CTEs
+	err = r.update(ctx, tx, review)
+	if err != nil {
+		return err
+	}
+
+	rating, err := ratingChange(ctx, tx, review.Prod_ID)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutbox(ctx, tx, withRatingChange(reviewEvents(EventReviewUpdated, review), rating))
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// the update itself without the avg_rating recalculation, shared with SaveBatch
+func (r ReviewModel) update(ctx context.Context, tx *sql.Tx, review *Review) error {
+	// lock the review first so concurrent edits number their revisions one after the other,
+	// the one that waited sees the other's revision and then fails the version check
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM review WHERE rid = $1 FOR UPDATE`, review.RID)
+	if err != nil {
+		return fmt.Errorf("locking review: %w", err)
+	}
+
+	// keep the current version of the review in review_revisions before overwriting it
+	// nothing is recorded (and the review isn't marked as edited) if nothing actually changed
+	revisionQuery := `
+		INSERT INTO review_revisions (rid, revision, rating, pros, cons, body)
+		SELECT r.rid,
+			COALESCE((SELECT MAX(revision) FROM review_revisions WHERE rid = r.rid), 0) + 1,
+			r.rating, r.pros, r.cons, r.body
+		FROM review r
+		WHERE r.rid = $1 AND r.deleted_at IS NULL
+		AND (r.rating, r.pros, r.cons, r.body)
+			IS DISTINCT FROM ($2::int, $3::text[], $4::text[], $5::text)
+		`
+	result, err := tx.ExecContext(
+		ctx,
+		revisionQuery,
+		review.RID,
+		review.Rating,
+		pq.Array(nonNil(review.Pros)),
+		pq.Array(nonNil(review.Cons)),
+		review.Body,
+	)
+	if err != nil {
+		return fmt.Errorf("recording review revision: %w", err)
+	}
+	revised, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking affected rows: %w", err)
+	}
+
+	// the fingerprint follows the text so later duplicate checks compare against the current version
+	var fpValue any
+	bands := []int64{}
+	if fp, ok := reviewFingerprint(review); ok {
+		fpValue, bands = int64(fp), fingerprint.Bands(fp)
+	}
+
+	// the text or the rating may have changed so the sentiment and language are worked out again
+	score, label, mismatch := reviewSentiment(review)
+	lang := reviewLanguage(review)
+
+	// Update the review, the product's avg_rating is recalculated right after
 	query := `
         WITH updated_review AS (
             UPDATE review
-            SET rating = $1
-            WHERE rid = $2
-            RETURNING rid, rating, prod_id
-        ),
-        update_avg AS (
-            UPDATE product p
-            SET avg_rating = (
-                SELECT ROUND(AVG(rating)::numeric, 2)
-                FROM review r
-                WHERE r.prod_id = (
-                    SELECT prod_id 
-                    FROM updated_review
-                )
-            )
-            WHERE pid = (
-                SELECT prod_id 
-                FROM updated_review
-            )
-            RETURNING pname
+            SET rating = $1, pros = $3, cons = $4, body = $6,
+                fingerprint = $7, fingerprint_bands = $8,
+                sentiment_score = $9, sentiment = $10, sentiment_mismatch = $11,
+                language = $12, search_config = $13::regconfig,
+                edited_at = CASE WHEN $5 THEN NOW() ELSE edited_at END,
+                version = version + 1
+            WHERE rid = $2 AND version = $14 AND deleted_at IS NULL
+            RETURNING *
         )
-        SELECT 
-            ur.rid,
-            ur.rating,
-            ua.pname
-        FROM updated_review ur
-        CROSS JOIN update_avg ua
+        SELECT ` + reviewColumns + `
+        FROM updated_review r
+        JOIN product p ON p.pid = r.prod_id
     `
-	err = tx.QueryRowContext(
+	row := tx.QueryRowContext(
 		ctx,
 		query,
 		review.Rating,
 		review.RID,
-	).Scan(
-		&review.RID,
-		&review.Rating,
-		&review.ProductName,
+		pq.Array(nonNil(review.Pros)),
+		pq.Array(nonNil(review.Cons)),
+		revised > 0,
+		review.Body,
+		fpValue,
+		pq.Array(bands),
+		score,
+		label,
+		mismatch,
+		lang,
+		language.SearchConfig(lang),
+		review.Version,
 	)
-
+	err = scanReview(row, review)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return fmt.Errorf("updating review: %w", err)
 		}
 	}
-
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("committing transaction: %w", err)
-	}
-
 	return nil
 }
 
@@ -245,7 +497,7 @@ func (r ReviewModel) UpdateHelpfulCount(rid int64, increment int8) error {
 	query := `
         UPDATE review
         SET helpful_count = GREATEST(0, helpful_count + $1)
-        WHERE rid = $2
+        WHERE rid = $2 AND deleted_at IS NULL AND status = 'published'
 		RETURNING helpful_count
     `
 
@@ -266,6 +518,8 @@ func (r ReviewModel) UpdateHelpfulCount(rid int64, increment int8) error {
 }
 
 // Cut/Delete Functionaity
+// reviews are soft deleted, the row stays until the purge command removes it
+// so an accidental delete can still be restored
 
 func (r ReviewModel) Delete(id int64) error {
 	// Input validation
@@ -282,37 +536,94 @@ func (r ReviewModel) Delete(id int64) error {
 	}
 	defer tx.Rollback()
 
-	// Delete review and update product's avg_rating in a single query
 	query := `
-        WITH deleted_review AS (
-            DELETE FROM review
-            WHERE rid = $1
-            RETURNING prod_id
-        ),
-        update_avg AS (
-            UPDATE product p
-            SET 
-                avg_rating = COALESCE(
-                    (SELECT ROUND(AVG(rating)::numeric,2)
-                     FROM review r
-                     WHERE r.prod_id = (SELECT prod_id FROM deleted_review)), 0
-                     -- Set to 0 if this was the last review
-                )
-            WHERE pid = (SELECT prod_id FROM deleted_review)
-		)
-		SELECT EXISTS(SELECT 1 FROM deleted_review)
+        UPDATE review
+        SET deleted_at = NOW()
+        WHERE rid = $1 AND deleted_at IS NULL
+        RETURNING prod_id, status
     `
-	// deleted bool to track whether it did successfully
-	var deleted bool
-	err = tx.QueryRowContext(ctx, query, id).Scan(&deleted)
+	var prodID int64
+	var status string
+	err = tx.QueryRowContext(ctx, query, id).Scan(&prodID, &status)
 	if err != nil {
-		return fmt.Errorf("deleting review: %w", err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return fmt.Errorf("deleting review: %w", err)
+		}
+	}
+
+	// the deleted review no longer counts towards the average
+	rating, err := ratingChange(ctx, tx, prodID)
+	if err != nil {
+		return err
+	}
+
+	// subscribers never heard of a review that wasn't published
+	var events []Event
+	if status == ReviewPublished {
+		events = []Event{newEvent(EventReviewDeleted, DeletedReview{RID: id, Prod_ID: prodID})}
+	}
+	err = writeOutbox(ctx, tx, withRatingChange(events, rating))
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commiting transaction: %w", err)
 	}
 
-	if !deleted {
+	return nil
+}
+
+// Restore undoes a soft delete, subscribers get the review back with a review.restored
+func (r ReviewModel) Restore(id int64) error {
+	if id < 1 {
 		return ErrRecordNotFound
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        WITH restored_review AS (
+            UPDATE review
+            SET deleted_at = NULL
+            WHERE rid = $1 AND deleted_at IS NOT NULL
+            RETURNING *
+        )
+        SELECT ` + reviewColumns + `
+        FROM restored_review r
+        JOIN product p ON p.pid = r.prod_id
+    `
+	var review Review
+	err = scanReview(tx.QueryRowContext(ctx, query, id), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return fmt.Errorf("restoring review: %w", err)
+		}
+	}
+
+	rating, err := ratingChange(ctx, tx, review.Prod_ID)
+	if err != nil {
+		return err
+	}
+
+	err = writeOutbox(ctx, tx, withRatingChange(reviewEvents(EventReviewRestored, &review), rating))
+	if err != nil {
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("commiting transaction: %w", err)
 	}
@@ -320,20 +631,100 @@ func (r ReviewModel) Delete(id int64) error {
 	return nil
 }
 
+// Purge permanently removes reviews that were soft deleted before the cutoff
+func (r ReviewModel) Purge(before time.Time) (int64, error) {
+	query := `
+        DELETE FROM review
+        WHERE deleted_at IS NOT NULL AND deleted_at < $1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*defaultTimeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("purging reviews: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// recalculates a product's avg_rating from its published reviews that haven't been deleted
+// it runs as its own statement inside the caller's transaction since the CTE version
+// couldn't see the row that was being inserted/updated in the same query
+func updateAvgRating(ctx context.Context, tx *sql.Tx, prodID int64) error {
+	query := `
+        UPDATE product
+        SET avg_rating = COALESCE(
+            (SELECT ROUND(AVG(rating)::numeric, 2)
+             FROM review
+             WHERE prod_id = $1 AND deleted_at IS NULL AND status = 'published'), 0
+             -- Set to 0 if there are no reviews left
+        )
+        WHERE pid = $1
+    `
+	_, err := tx.ExecContext(ctx, query, prodID)
+	if err != nil {
+		return fmt.Errorf("updating avg_rating: %w", err)
+	}
+	return nil
+}
+
+// the optional filters for GetAll, the zero value of a field means "don't filter on it"
+type ReviewQuery struct {
+	Prod_ID       int
+	Rating        int
+	Helpful_Count int
+	Verified      bool     // only verified purchases
+	Sentiment     string   // positive, neutral or negative
+	Search        string   // full text search on the body, using each review's own language
+	Language      string   // only reviews in this language
+	Preferred     []string // languages listed first (from Accept-Language), best first
+}
+
+// the sort values for review lists, REST, GraphQL and gRPC all use this one
+var ReviewSortSafeList = []string{
+	"rid", "rating", "helpful_count", "created_at",
+	"-rid", "-rating", "-helpful_count", "-created_at",
+}
+
+// checks the ReviewQuery filters that can't be any value, the API layers all call this
+// so a bad ?sentiment= gets the same message everywhere
+func ValidateReviewQuery(v *validator.Validator, q ReviewQuery) {
+	if q.Sentiment != "" {
+		v.Check(validator.PermittedValue(q.Sentiment,
+			sentiment.Positive, sentiment.Neutral, sentiment.Negative),
+			"sentiment", "must be positive, neutral or negative")
+	}
+	if q.Language != "" {
+		v.Check(language.Supported(q.Language), "lang", "must be a supported language code")
+	}
+}
+
 // Get all comments
-// Same deal as product's GetAll(), query works but issue with how reviews[] array and/or metadata{} are populated
-func (r ReviewModel) GetAll(prod_id int, rating int, helpful_count int, filters Filters) ([]*Review, Metadata, error) {
+// the int filters were being compared as text against an empty string which never matched anything
+// so 0 is now used for "no filter"
+// the sort column is prefixed with r. since created_at is also a product column
+// reviews in the preferred languages come first, then the requested sort applies
+// the filters GetAll and Export share, $1-$3 and $6-$9 are the ReviewQuery fields
+// ($4 and $5 are left for paging)
+const reviewListFilters = `r.deleted_at IS NULL AND p.deleted_at IS NULL
+		AND		r.status = 'published'
+		AND		(r.prod_id = $1 OR $1 = 0)
+		AND		(r.rating = $2 OR $2 = 0)
+		AND		(r.helpful_count = $3 OR $3 = 0)
+		AND		(r.verified_purchase OR NOT $6)
+		AND		(r.sentiment = $7 OR $7 = '')
+		AND		(r.search_vector @@ plainto_tsquery(r.search_config, $8) OR $8 = '')
+		AND		(r.language = $9 OR $9 = '')`
+
+func (r ReviewModel) GetAll(q ReviewQuery, filters Filters) ([]*Review, Metadata, error) {
 
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), 
-			r.rid, r.created_at, r.prod_id,
-			r.rating, r.helpful_count, p.pname
+		SELECT COUNT(*) OVER(), `+reviewColumns+`
 		FROM review r
 		JOIN product p ON p.pid = r.prod_id
-		WHERE 	(CAST(r.prod_id AS TEXT) = $1 OR $1 = '')
-		AND		(CAST(r.rating AS TEXT) = $2 OR $2 = '')
-		AND		(CAST(r.helpful_count AS TEXT) = $3 OR $3 = '')
-		ORDER BY %s %s, r.rid ASC
+		WHERE 	`+reviewListFilters+`
+		ORDER BY array_position($10::text[], r.language) ASC NULLS LAST, r.%s %s, r.rid ASC
 		LIMIT $4 OFFSET $5
 		`, filters.sortColumn(), filters.sortDirection())
 
@@ -341,7 +732,10 @@ func (r ReviewModel) GetAll(prod_id int, rating int, helpful_count int, filters
 	defer cancel()
 
 	// Query context returns multiple rows
-	rows, err := r.DB.QueryContext(ctx, query, prod_id, rating, helpful_count, filters.limit(), filters.offset())
+	rows, err := r.DB.QueryContext(ctx, query,
+		q.Prod_ID, q.Rating, q.Helpful_Count,
+		filters.limit(), filters.offset(),
+		q.Verified, q.Sentiment, q.Search, q.Language, pq.Array(nonNil(q.Preferred)))
 	if err != nil {
 		return nil, Metadata{}, fmt.Errorf("querying reviews: %w", err)
 	}
@@ -355,14 +749,7 @@ func (r ReviewModel) GetAll(prod_id int, rating int, helpful_count int, filters
 	// process each row that is in the var rows
 	for rows.Next() {
 		var review Review
-		err := rows.Scan(
-			&totalRecords,
-			&review.RID,
-			&review.CreatedAt,
-			&review.Prod_ID,
-			&review.Rating,
-			&review.Helpful_Count,
-			&review.ProductName)
+		err := scanReview(rows, &review, &totalRecords)
 		if err != nil {
 			return nil, Metadata{}, fmt.Errorf("scanning review row: %w", err)
 		}
@@ -378,3 +765,153 @@ func (r ReviewModel) GetAll(prod_id int, rating int, helpful_count int, filters
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 	return reviews, metadata, nil
 }
+
+// scores the written review, pros and cons are left out since they're
+// positive and negative by definition and would just drown out the body
+// score is nil (NULL) when there's no text to score
+func reviewSentiment(review *Review) (score *float64, label string, mismatch bool) {
+	result, ok := sentiment.Analyze(review.Body)
+	if !ok {
+		return nil, "", false
+	}
+	return &result.Score, result.Label, sentiment.Mismatch(review.Rating, result.Label)
+}
+
+// the language of the body (with the pros and cons as extra text for short reviews)
+func reviewLanguage(review *Review) string {
+	return language.Detect(reviewText(review))
+}
+
+// pq.Array turns a nil slice into NULL, the pros/cons columns want an empty array
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// a single aggregated pro or con, e.g. "long battery life" mentioned 42 times
+type Highlight struct {
+	Phrase string `json:"phrase"`
+	Count  int    `json:"count"`
+}
+
+// what customers say about a product
+type ProductHighlights struct {
+	Prod_ID int64       `json:"prod_id"`
+	Pros    []Highlight `json:"pros"`
+	Cons    []Highlight `json:"cons"`
+}
+
+// the most pros or cons Highlights sends back for each list
+const maxHighlights = 20
+
+// Highlights aggregates the pros and cons of every review for a product
+// entries are grouped on their normalized key (folded + stemmed) so
+// "Long battery life!" and "long-lasting batteries" style variations are counted together
+// the most common spelling of each group is the one that gets displayed
+func (r ReviewModel) Highlights(prod_id int64, limit int) (*ProductHighlights, error) {
+	// the API checks the limit, this only keeps a bad one from panicking or sending every phrase
+	limit = min(max(limit, 0), maxHighlights)
+
+	query := `
+		SELECT 'pro', entry
+		FROM review r, unnest(r.pros) AS entry
+		WHERE r.prod_id = $1 AND r.deleted_at IS NULL AND r.status = 'published'
+		UNION ALL
+		SELECT 'con', entry
+		FROM review r, unnest(r.cons) AS entry
+		WHERE r.prod_id = $1 AND r.deleted_at IS NULL AND r.status = 'published'
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, prod_id)
+	if err != nil {
+		return nil, fmt.Errorf("querying highlights: %w", err)
+	}
+	defer rows.Close()
+
+	pros := newHighlightCounter()
+	cons := newHighlightCounter()
+	for rows.Next() {
+		var kind, entry string
+		err := rows.Scan(&kind, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("scanning highlight row: %w", err)
+		}
+		if kind == "pro" {
+			pros.add(entry)
+		} else {
+			cons.add(entry)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductHighlights{
+		Prod_ID: prod_id,
+		Pros:    pros.top(limit),
+		Cons:    cons.top(limit),
+	}, nil
+}
+
+// counts phrases per normalized key and remembers how each key was spelled
+type highlightCounter struct {
+	counts    map[string]int
+	spellings map[string]map[string]int
+}
+
+func newHighlightCounter() *highlightCounter {
+	return &highlightCounter{
+		counts:    make(map[string]int),
+		spellings: make(map[string]map[string]int),
+	}
+}
+
+func (h *highlightCounter) add(entry string) {
+	key := normalize.Key(entry)
+	if key == "" {
+		return
+	}
+	h.counts[key]++
+	if h.spellings[key] == nil {
+		h.spellings[key] = make(map[string]int)
+	}
+	h.spellings[key][normalize.Fold(entry)]++
+}
+
+// returns the most mentioned phrases, ties are broken alphabetically so the output is stable
+func (h *highlightCounter) top(limit int) []Highlight {
+	highlights := []Highlight{}
+	for key, count := range h.counts {
+		highlights = append(highlights, Highlight{Phrase: h.label(key), Count: count})
+	}
+
+	sort.Slice(highlights, func(i, j int) bool {
+		if highlights[i].Count != highlights[j].Count {
+			return highlights[i].Count > highlights[j].Count
+		}
+		return highlights[i].Phrase < highlights[j].Phrase
+	})
+
+	if len(highlights) > limit {
+		highlights = highlights[:limit]
+	}
+	return highlights
+}
+
+// the most common spelling for a key
+func (h *highlightCounter) label(key string) string {
+	label, best := "", 0
+	for spelling, count := range h.spellings[key] {
+		if count > best || (count == best && spelling < label) {
+			label, best = spelling, count
+		}
+	}
+	return label
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// a previous version of a review, written by ReviewModel.Update right before it overwrites the row
+type ReviewRevision struct {
+	RID        int64     `json:"rid"`         // review the revision belongs to
+	Revision   int       `json:"revision"`    // 1 is the version that was originally posted
+	Rating     int8      `json:"rating"`      // rating at the time
+	Body       string    `json:"body"`        // written review at the time
+	Pros       []string  `json:"pros"`        // pros at the time
+	Cons       []string  `json:"cons"`        // cons at the time
+	ReplacedAt time.Time `json:"replaced_at"` // when this version was overwritten
+}
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// Get a single revision of a review
+func (m RevisionModel) Get(rid int64, revision int) (*ReviewRevision, error) {
+	if rid < 1 || revision < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT rid, revision, rating, body, pros, cons, replaced_at
+		FROM review_revisions
+		WHERE rid = $1 AND revision = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var rev ReviewRevision
+	err := m.DB.QueryRowContext(ctx, query, rid, revision).Scan(
+		&rev.RID,
+		&rev.Revision,
+		&rev.Rating,
+		&rev.Body,
+		pq.Array(&rev.Pros),
+		pq.Array(&rev.Cons),
+		&rev.ReplacedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("getting review revision: %w", err)
+		}
+	}
+	return &rev, nil
+}
+
+// GetAll returns the full edit history of a review, oldest first
+func (m RevisionModel) GetAll(rid int64) ([]*ReviewRevision, error) {
+	query := `
+		SELECT rid, revision, rating, body, pros, cons, replaced_at
+		FROM review_revisions
+		WHERE rid = $1
+		ORDER BY revision ASC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, rid)
+	if err != nil {
+		return nil, fmt.Errorf("querying review revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []*ReviewRevision{}
+	for rows.Next() {
+		var rev ReviewRevision
+		err := rows.Scan(
+			&rev.RID,
+			&rev.Revision,
+			&rev.Rating,
+			&rev.Body,
+			pq.Array(&rev.Pros),
+			pq.Array(&rev.Cons),
+			&rev.ReplacedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning review revision row: %w", err)
+		}
+		revisions = append(revisions, &rev)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// the channel the review_changes trigger notifies on, the payload is the id of the change
+const ReviewChangesChannel = "review_changes"
+
+// an insert or update of a review as recorded by the review_changes trigger, with the review as
+// it is now (a replayed change shows the latest version, not the one it had back then)
+type ReviewChange struct {
+	ID       int64  // sent as the SSE event id
+	XID      int64  // the transaction that made the change
+	Type     string // EventReviewCreated or EventReviewUpdated
+	Review   *Review
+	Category string // of the review's product, for the category filter
+	Visible  bool   // published and not deleted, only those are streamed
+}
+
+// where a change is in the stream
+// the changes are streamed by transaction and then id, and only once every transaction before
+// theirs is over (below the xmin of the current snapshot) so nothing can turn up behind a position
+// later on, going by id alone would skip a change whose transaction commits after a later one
+// a long running transaction holds the stream back until it's done
+type ChangePosition struct {
+	XID int64
+	ID  int64
+}
+
+func (p ChangePosition) Before(other ChangePosition) bool {
+	return p.XID < other.XID || (p.XID == other.XID && p.ID < other.ID)
+}
+
+func (c *ReviewChange) Position() ChangePosition {
+	return ChangePosition{XID: c.XID, ID: c.ID}
+}
+
+// the oldest transaction still running, the changes of the ones before it are final
+const changeHorizon = `pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+
+// ChangesSince gives up to limit changes after the given position, in stream order
+// hidden reviews are included (not Visible) so the caller can move past them
+// held is true when there are changes waiting on an older transaction, they come up in a later
+// call once it's over (it doesn't always notify, it might not have changed a review)
+func (r ReviewModel) ChangesSince(after ChangePosition, limit int) ([]*ReviewChange, bool, error) {
+	query := `
+		SELECT c.id, c.xid, c.op, COALESCE(p.product_category, ''),
+			r.status = 'published' AND r.deleted_at IS NULL, ` + reviewColumns + `
+		FROM review_changes c
+		JOIN review r ON r.rid = c.rid
+		JOIN product p ON p.pid = r.prod_id
+		WHERE (c.xid, c.id) > ($1::bigint, $2::bigint)
+		AND c.xid < ` + changeHorizon + `
+		ORDER BY c.xid, c.id
+		LIMIT $3
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, after.XID, after.ID, limit)
+	if err != nil {
+		return nil, false, fmt.Errorf("querying review changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []*ReviewChange{}
+	for rows.Next() {
+		change := ReviewChange{Review: &Review{}}
+		var op string
+		err := scanReview(rows, change.Review, &change.ID, &change.XID, &op, &change.Category, &change.Visible)
+		if err != nil {
+			return nil, false, fmt.Errorf("scanning review change row: %w", err)
+		}
+		change.Type = EventReviewUpdated
+		if op == "insert" {
+			change.Type = EventReviewCreated
+		}
+		changes = append(changes, &change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	var held bool
+	err = r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM review_changes WHERE xid >= `+changeHorizon+`)`).Scan(&held)
+	if err != nil {
+		return nil, false, fmt.Errorf("checking for held back review changes: %w", err)
+	}
+	return changes, held, nil
+}
+
+// LatestPosition is the position of the last change that can be streamed, the zero position
+// when there are none
+func (r ReviewModel) LatestPosition() (ChangePosition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT xid, id
+		FROM review_changes
+		WHERE xid < ` + changeHorizon + `
+		ORDER BY xid DESC, id DESC
+		LIMIT 1
+		`
+	var position ChangePosition
+	err := r.DB.QueryRowContext(ctx, query).Scan(&position.XID, &position.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ChangePosition{}, fmt.Errorf("getting latest review change: %w", err)
+	}
+	return position, nil
+}
+
+// ChangePosition is the position of the change with the given id (a Last-Event-ID), the zero
+// position when it's gone so the client gets the changes that are left
+func (r ReviewModel) ChangePosition(id int64) (ChangePosition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	position := ChangePosition{ID: id}
+	err := r.DB.QueryRowContext(ctx, `SELECT xid FROM review_changes WHERE id = $1`, id).Scan(&position.XID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ChangePosition{}, nil
+		}
+		return ChangePosition{}, fmt.Errorf("getting review change: %w", err)
+	}
+	return position, nil
+}
+
+// PurgeChanges removes the changes recorded before the cutoff, a client resuming from one of
+// them only gets the changes that are left
+func (r ReviewModel) PurgeChanges(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*defaultTimeout)
+	defer cancel()
+
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM review_changes WHERE changed_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("purging review changes: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,484 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/validator"
+	"github.com/ReynerioSamos/reviews/internal/webhook"
+	"github.com/lib/pq"
+)
+
+// states of a delivery, pending ones are picked up by the dispatcher when they're due
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // out of attempts, only a manual redelivery sends it again
+)
+
+// a subscription to some of the EventTypes
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"` // only shown once, when the webhook is created
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+// an event on its way to one subscription
+type WebhookDelivery struct {
+	ID            int64             `json:"id"`
+	Webhook_ID    int64             `json:"webhook_id"`
+	Event_ID      string            `json:"event_id"`
+	Event_Type    string            `json:"event_type"`
+	Payload       json.RawMessage   `json:"payload"` // the body that's sent, the Event as JSON
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	DeliveredAt   *time.Time        `json:"delivered_at,omitempty"`
+	Log           []*WebhookAttempt `json:"log"`
+	URL           string            `json:"-"` // where it goes, for the dispatcher
+	Secret        string            `json:"-"` // what it's signed with, for the dispatcher
+}
+
+// one try at a delivery
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	Status_Code *int      `json:"status_code"` // nil when there was no response (timeout, refused...)
+	Error       string    `json:"error,omitempty"`
+	Duration_MS int       `json:"duration_ms"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func ValidateWebhook(v *validator.Validator, hook *Webhook) {
+	u, err := url.Parse(hook.URL)
+	v.Check(hook.URL != "", "url", "must be provided")
+	v.Check(len(hook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	v.Check(absolute, "url", "must be an absolute http or https URL")
+	// nothing on our own network, the client checks the address again when it connects
+	if absolute {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		v.Check(webhook.CheckHost(ctx, u.Hostname()) == nil, "url", "must point to a public address")
+	}
+
+	v.Check(len(hook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(hook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+
+	v.Check(len(hook.Events) > 0, "events", "must contain at least one event type")
+	seen := make(map[string]bool)
+	for _, event := range hook.Events {
+		v.Check(validator.PermittedValue(event, EventTypes...), "events", "must only contain known event types")
+		v.Check(!seen[event], "events", "must not contain duplicate values")
+		seen[event] = true
+	}
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active).
+		Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, url, secret, events, active, created_at, version
+		FROM webhooks
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var webhook Webhook
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.CreatedAt,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("getting webhook: %w", err)
+		}
+	}
+	return &webhook, nil
+}
+
+// GetAll lists the subscriptions, oldest first
+func (m WebhookModel) GetAll(filters Filters) ([]*Webhook, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), id, url, events, active, created_at, version
+		FROM webhooks
+		ORDER BY id
+		LIMIT $1 OFFSET $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&totalRecords,
+			&webhook.ID,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.CreatedAt,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, fmt.Errorf("scanning webhook row: %w", err)
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return webhooks, metadata, nil
+}
+
+// webhook.Version has to be the version that was read, ErrEditConflict otherwise
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, secret = $2, events = $3, active = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, webhook.URL, webhook.Secret, pq.Array(webhook.Events),
+		webhook.Active, webhook.ID, webhook.Version).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return fmt.Errorf("updating webhook: %w", err)
+		}
+	}
+	return nil
+}
+
+// Delete removes the subscription with its deliveries and their log
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Enqueue queues a delivery of the event for each active webhook subscribed to its type
+// the outbox relay can hand over the same event again, it's still only delivered once per webhook
+func (m WebhookModel) Enqueue(event *OutboxEvent) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $1::text, $2::text, $3::jsonb
+		FROM webhooks
+		WHERE active AND $2 = ANY(events)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// as a string, a []byte would be sent as bytea
+	_, err := m.DB.ExecContext(ctx, query, event.Event_ID, event.Event_Type, string(event.Payload))
+	if err != nil {
+		return fmt.Errorf("queueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue takes up to limit pending deliveries that are due, with where they go
+// they're pushed back by lease so another dispatcher (or this one after a crash) only tries them
+// again if the attempt never got recorded
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.active
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING ` + deliveryColumns + `, w.url, w.secret
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(rows, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook delivery row: %w", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordAttempt logs an attempt at a delivery and moves it on: succeeded when it went through,
+// otherwise pending again until retryAt or failed when there's no retryAt (out of attempts)
+func (m WebhookModel) RecordAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt, succeeded bool, retryAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO webhook_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING attempted_at
+		`, delivery.ID, attempt.AttemptedAt, attempt.Status_Code, attempt.Error, attempt.Duration_MS).
+		Scan(&attempt.AttemptedAt)
+	if err != nil {
+		return fmt.Errorf("recording webhook attempt: %w", err)
+	}
+
+	status, nextAttemptAt := DeliveryFailed, any(nil)
+	switch {
+	case succeeded:
+		status = DeliverySucceeded
+	case retryAt != nil:
+		status, nextAttemptAt = DeliveryPending, *retryAt
+	}
+	query := `
+		UPDATE webhook_deliveries d
+		SET status = $2, attempts = d.attempts + 1,
+			next_attempt_at = COALESCE($3, d.next_attempt_at),
+			delivered_at = CASE WHEN $2 = 'succeeded' THEN NOW() ELSE d.delivered_at END
+		WHERE d.id = $1
+		RETURNING ` + deliveryColumns
+	err = scanDelivery(tx.QueryRowContext(ctx, query, delivery.ID, status, nextAttemptAt), delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// the webhook was deleted while the delivery was on its way
+			return ErrRecordNotFound
+		default:
+			return fmt.Errorf("updating webhook delivery: %w", err)
+		}
+	}
+	delivery.Log = append(delivery.Log, attempt)
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// GetDeliveries is the delivery log of a webhook, newest first, status "" is any status
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT ` + deliveryColumns + `, COUNT(*) OVER()
+		FROM webhook_deliveries d
+		WHERE d.webhook_id = $1 AND (d.status = $2 OR $2 = '')
+		ORDER BY d.id DESC
+		LIMIT $3 OFFSET $4
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, fmt.Errorf("querying webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(rows, &delivery, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, fmt.Errorf("scanning webhook delivery row: %w", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.loadAttempts(ctx, deliveries)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
+
+// GetDelivery is one delivery of a webhook with its log and where it goes
+func (m WebhookModel) GetDelivery(webhookID, id int64) (*WebhookDelivery, error) {
+	if webhookID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + deliveryColumns + `, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1 AND d.webhook_id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var delivery WebhookDelivery
+	err := scanDelivery(m.DB.QueryRowContext(ctx, query, id, webhookID), &delivery, &delivery.URL, &delivery.Secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("getting webhook delivery: %w", err)
+		}
+	}
+
+	err = m.loadAttempts(ctx, []*WebhookDelivery{&delivery})
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// fills in the log of the deliveries, oldest attempt first
+func (m WebhookModel) loadAttempts(ctx context.Context, deliveries []*WebhookDelivery) error {
+	ids := make([]int64, len(deliveries))
+	byID := make(map[int64]*WebhookDelivery, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+		byID[delivery.ID] = delivery
+		delivery.Log = []*WebhookAttempt{}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT delivery_id, attempted_at, status_code, error, duration_ms
+		FROM webhook_attempts
+		WHERE delivery_id = ANY($1)
+		ORDER BY id
+		`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("querying webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID int64
+		var attempt WebhookAttempt
+		err := rows.Scan(&deliveryID, &attempt.AttemptedAt, &attempt.Status_Code, &attempt.Error, &attempt.Duration_MS)
+		if err != nil {
+			return fmt.Errorf("scanning webhook attempt row: %w", err)
+		}
+		byID[deliveryID].Log = append(byID[deliveryID].Log, &attempt)
+	}
+	return rows.Err()
+}
+
+// the columns scanDelivery expects, in order
+// they're qualified with d so the queries using them call webhook_deliveries d
+const deliveryColumns = `d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	d.next_attempt_at, d.created_at, d.delivered_at`
+
+func (d *WebhookDelivery) columns() []any {
+	return []any{
+		&d.ID,
+		&d.Webhook_ID,
+		&d.Event_ID,
+		&d.Event_Type,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.CreatedAt,
+		&d.DeliveredAt,
+	}
+}
+
+// scans deliveryColumns, followed by any extra columns of the query
+func scanDelivery(row interface{ Scan(...any) error }, delivery *WebhookDelivery, extra ...any) error {
+	return row.Scan(append(delivery.columns(), extra...)...)
+}
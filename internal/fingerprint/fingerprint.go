@@ -0,0 +1,76 @@
+package fingerprint
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/normalize"
+)
+
+const (
+	// size of the word shingles the simhash is built from
+	// single words work best for reviews, they're short enough that changing one word
+	// touches a big part of the longer shingles and pushes copies too far apart
+	shingleSize = 1
+	// texts shorter than this (in words) are too generic to compare
+	// "great product, works well" is going to be posted by plenty of real people
+	MinTokens = 8
+	// fingerprints within this many bits of each other are near-duplicates
+	MaxDistance = 3
+	// the 64 bits are split into bands of 16 bits, two fingerprints that are at most
+	// MaxDistance bits apart always have at least one band that is exactly the same
+	bandCount = 4
+	bandBits  = 64 / bandCount
+)
+
+// Simhash builds a 64 bit fingerprint of the text from its shingles
+// similar texts end up with fingerprints that only differ in a couple of bits
+// ok is false when the text is too short to be fingerprinted
+func Simhash(text string) (fp uint64, ok bool) {
+	tokens := normalize.Tokens(text)
+	if len(tokens) < MinTokens {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		h := hash(strings.Join(tokens[i:i+shingleSize], " "))
+		for bit := 0; bit < 64; bit++ {
+			if h&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fp |= 1 << bit
+		}
+	}
+	return fp, true
+}
+
+// Distance is the number of bits that differ between two fingerprints
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Bands splits the fingerprint into lookup keys, the band number is kept
+// in the upper bits so the same value in two different bands doesn't match
+func Bands(fp uint64) []int64 {
+	bands := make([]int64, bandCount)
+	for i := 0; i < bandCount; i++ {
+		value := (fp >> (i * bandBits)) & (1<<bandBits - 1)
+		bands[i] = int64(i)<<bandBits | int64(value)
+	}
+	return bands
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
@@ -0,0 +1,198 @@
+package language
+
+import (
+	"embed"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/normalize"
+)
+
+const (
+	// the longest n-grams in a profile
+	maxGram = 3
+	// how many of the most frequent n-grams make up a profile
+	profileSize = 300
+	// texts with fewer letters than this are too short to guess
+	minLetters = 20
+)
+
+// one sample text per language, the file name is the ISO 639-1 code
+//
+//go:embed samples/*.txt
+var samples embed.FS
+
+// the text search configuration PostgreSQL should use for each language
+var searchConfigs = map[string]string{
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fr": "french",
+	"it": "italian",
+	"nl": "dutch",
+	"pt": "portuguese",
+}
+
+// n-gram -> rank, built once from the samples
+var profiles = loadProfiles()
+
+// Detect guesses the language of the text by comparing its character n-grams
+// with the profile of every bundled language (Cavnar & Trenkle "out of place" distance)
+// it returns the ISO 639-1 code or "" when the text is too short to tell
+func Detect(text string) string {
+	folded := normalize.Fold(text)
+	letters := 0
+	for _, r := range folded {
+		if r != ' ' {
+			letters++
+		}
+	}
+	if letters < minLetters {
+		return ""
+	}
+
+	ranked := rank(folded)
+	best, bestDistance := "", -1
+	for code, profile := range profiles {
+		distance := 0
+		for gram, position := range ranked {
+			profilePosition, found := profile[gram]
+			if !found {
+				distance += profileSize
+				continue
+			}
+			distance += abs(position - profilePosition)
+		}
+		if bestDistance == -1 || distance < bestDistance || (distance == bestDistance && code < best) {
+			best, bestDistance = code, distance
+		}
+	}
+	return best
+}
+
+// Supported reports whether the code is one of the bundled languages
+func Supported(code string) bool {
+	_, found := searchConfigs[code]
+	return found
+}
+
+// SearchConfig returns the PostgreSQL text search configuration for the language
+// "simple" (no stemming, no stop words) when the language is unknown
+func SearchConfig(code string) string {
+	config, found := searchConfigs[code]
+	if !found {
+		return "simple"
+	}
+	return config
+}
+
+// Preferred parses an Accept-Language header into the bundled language codes the client
+// asked for, best first. "es-MX,es;q=0.9,en;q=0.8" -> [es en]
+func Preferred(header string) []string {
+	type choice struct {
+		code    string
+		quality float64
+	}
+	choices := []choice{}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !Supported(code) || seen[code] {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			quality = parseQuality(value)
+		}
+		if quality <= 0 {
+			continue
+		}
+		seen[code] = true
+		choices = append(choices, choice{code: code, quality: quality})
+	}
+
+	// stable so languages with the same quality keep the order the client sent them in
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].quality > choices[j].quality
+	})
+
+	codes := make([]string, len(choices))
+	for i, c := range choices {
+		codes[i] = c.code
+	}
+	return codes
+}
+
+// counts every 1 to maxGram letter n-gram of every word (padded with spaces)
+// and returns the profileSize most frequent ones with their rank
+func rank(folded string) map[string]int {
+	counts := make(map[string]int)
+	for _, word := range strings.Fields(folded) {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= maxGram; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if gram == " " {
+					continue
+				}
+				counts[gram]++
+			}
+		}
+	}
+
+	grams := make([]string, 0, len(counts))
+	for gram := range counts {
+		grams = append(grams, gram)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > profileSize {
+		grams = grams[:profileSize]
+	}
+
+	ranked := make(map[string]int, len(grams))
+	for i, gram := range grams {
+		ranked[gram] = i
+	}
+	return ranked
+}
+
+func loadProfiles() map[string]map[string]int {
+	entries, err := samples.ReadDir("samples")
+	if err != nil {
+		panic("language: reading samples: " + err.Error())
+	}
+	loaded := make(map[string]map[string]int, len(entries))
+	for _, entry := range entries {
+		text, err := samples.ReadFile(path.Join("samples", entry.Name()))
+		if err != nil {
+			panic("language: reading sample: " + err.Error())
+		}
+		code := strings.TrimSuffix(entry.Name(), ".txt")
+		loaded[code] = rank(normalize.Fold(string(text)))
+	}
+	return loaded
+}
+
+// q values go from 0 to 1, anything unparsable counts as 0
+func parseQuality(value string) float64 {
+	quality, err := strconv.ParseFloat(value, 64)
+	if err != nil || quality < 0 || quality > 1 {
+		return 0
+	}
+	return quality
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
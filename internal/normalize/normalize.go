@@ -0,0 +1,92 @@
+package normalize
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Fold lowercases the text, turns anything that isn't a letter or a digit into
+// a space and collapses the runs of spaces so "Long  Battery-Life!" and
+// "long battery life" end up being the same string
+func Fold(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+			continue
+		}
+		space = true
+	}
+	return b.String()
+}
+
+// Tokens splits the folded text into words
+func Tokens(s string) []string {
+	return strings.Fields(Fold(s))
+}
+
+// Stem strips the common english suffixes from a (lowercase) word
+// it's a light stemmer and not a full Porter implementation, it only needs to
+// make "batteries" and "battery" or "lasting" and "lasts" land on the same key
+func Stem(word string) string {
+	// short words are left alone, stripping them does more harm than good
+	if len([]rune(word)) <= 3 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "sses"):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"):
+		return word
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		return undouble(strings.TrimSuffix(word, "ing"))
+	case strings.HasSuffix(word, "edly") && len(word) > 6:
+		return undouble(strings.TrimSuffix(word, "edly"))
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		return undouble(strings.TrimSuffix(word, "ed"))
+	case strings.HasSuffix(word, "ly") && len(word) > 4:
+		return strings.TrimSuffix(word, "ly")
+	case strings.HasSuffix(word, "es") && hasSibilantStem(word):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "s"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
+
+// Key is the grouping key for a short phrase: folded, then every word stemmed
+func Key(s string) string {
+	tokens := Tokens(s)
+	for i, token := range tokens {
+		tokens[i] = Stem(token)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// "boxes", "watches", "dishes" drop the whole "es"
+func hasSibilantStem(word string) bool {
+	stem := strings.TrimSuffix(word, "es")
+	for _, suffix := range []string{"x", "z", "ch", "sh", "s"} {
+		if strings.HasSuffix(stem, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// "stopped" -> "stopp" -> "stop"
+func undouble(stem string) string {
+	n := len(stem)
+	if n >= 2 && stem[n-1] == stem[n-2] && !strings.ContainsRune("aeiouls", rune(stem[n-1])) {
+		return stem[:n-1]
+	}
+	return stem
+}
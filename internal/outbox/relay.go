@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/ReynerioSamos/reviews/internal/webhook"
+)
+
+// the relay takes the events the models wrote to the outbox and publishes them to the sinks
+// an event is only marked published once every sink has it, so after a crash or a sink failure
+// it's published again (to all of them): at-least-once, consumers should use the event id to
+// spot the repeats
+
+// a place the events are published to
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, event *data.OutboxEvent) error
+}
+
+type Relay struct {
+	Model      data.OutboxModel
+	Sinks      []Sink
+	Logger     *slog.Logger
+	Poll       time.Duration // how often the outbox is checked
+	Timeout    time.Duration // how long the sinks have for one event
+	Backoff    time.Duration // wait before the first retry of an event, doubled after every failure
+	MaxBackoff time.Duration
+}
+
+// how many events are claimed at a time
+const claimLimit = 100
+
+// Run relays the events until the context is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.relayDue(ctx)
+		}
+	}
+}
+
+// publishes everything that's due, a batch at a time, in the order it was written
+func (r *Relay) relayDue(ctx context.Context) {
+	// a claimed event is left alone for longer than the sinks can take with it
+	lease := r.Timeout + time.Minute
+
+	for ctx.Err() == nil {
+		events, err := r.Model.Claim(claimLimit, lease)
+		if err != nil {
+			r.Logger.Error(err.Error())
+			return
+		}
+
+		for _, event := range events {
+			err := r.publish(ctx, event)
+			if err != nil {
+				retryAt := time.Now().Add(webhook.Backoff(event.Attempts+1, r.Backoff, r.MaxBackoff))
+				r.Logger.Error(err.Error(), "event_id", event.Event_ID, "attempts", event.Attempts+1)
+				err = r.Model.MarkFailed(event.ID, err.Error(), retryAt)
+			} else {
+				err = r.Model.MarkPublished(event.ID)
+			}
+			if err != nil {
+				// the lease runs out and the event goes round again
+				r.Logger.Error(err.Error(), "event_id", event.Event_ID)
+			}
+		}
+
+		if len(events) < claimLimit {
+			return
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, event *data.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	for _, sink := range r.Sinks {
+		err := sink.Publish(ctx, event)
+		if err != nil {
+			return fmt.Errorf("publishing %s to %s: %w", event.Event_Type, sink.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ReynerioSamos/reviews/internal/data"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// queues the event for the webhooks subscribed to it, the dispatcher sends it from there
+type WebhookSink struct {
+	Model data.WebhookModel
+}
+
+func (s WebhookSink) Name() string { return "webhooks" }
+
+func (s WebhookSink) Publish(ctx context.Context, event *data.OutboxEvent) error {
+	return s.Model.Enqueue(event)
+}
+
+// writes every event as a line of JSON, for development or a log shipper
+type StdoutSink struct {
+	mu     sync.Mutex
+	Writer io.Writer
+}
+
+func (s *StdoutSink) Name() string { return "stdout" }
+
+func (s *StdoutSink) Publish(ctx context.Context, event *data.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.Writer, "%s\n", event.Payload)
+	return err
+}
+
+// publishes to NATS JetStream on <prefix>.<event type> (e.g. reviews.review.created), a stream
+// has to be set up on <prefix>.> for the events to be kept
+// the server acknowledges every event and drops a repeat within its duplicate window since the
+// event id is sent as the message id
+type NATSSink struct {
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	prefix string
+}
+
+func NewNATSSink(url, prefix string) (*NATSSink, error) {
+	conn, err := nats.Connect(url, nats.Name("reviews-outbox"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("opening JetStream: %w", err)
+	}
+	return &NATSSink{conn: conn, js: js, prefix: prefix}, nil
+}
+
+func (s *NATSSink) Name() string { return "nats" }
+
+func (s *NATSSink) Publish(ctx context.Context, event *data.OutboxEvent) error {
+	_, err := s.js.Publish(ctx, s.prefix+"."+event.Event_Type, event.Payload, jetstream.WithMsgID(event.Event_ID))
+	return err
+}
+
+func (s *NATSSink) Close() {
+	s.conn.Drain()
+}
@@ -0,0 +1,128 @@
+package sentiment
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ReynerioSamos/reviews/internal/normalize"
+)
+
+// labels a score is bucketed into
+const (
+	Positive = "positive"
+	Neutral  = "neutral"
+	Negative = "negative"
+)
+
+const (
+	// scores between -threshold and +threshold are neutral
+	threshold = 0.05
+	// normalisation constant, a bigger alpha needs more words to reach +-1
+	alpha = 15
+	// how many words after a negation have their polarity flipped
+	negationScope = 3
+	// "very good" counts more than "good"
+	intensifierBoost = 1.5
+)
+
+//go:embed lexicon.txt
+var lexiconFile string
+
+// word -> score, loaded once from the bundled file
+var lexicon = loadLexicon(lexiconFile)
+
+// negations are matched after folding so "don't" shows up as "don" "t"
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "nobody": true, "neither": true, "nor": true,
+	"t": true, "dont": true, "doesnt": true, "didnt": true, "isnt": true, "wasnt": true, "cant": true,
+	"cannot": true, "wont": true, "wouldnt": true, "hardly": true, "barely": true, "without": true,
+}
+
+var intensifiers = map[string]bool{
+	"very": true, "really": true, "extremely": true, "super": true, "so": true, "incredibly": true,
+	"absolutely": true, "totally": true, "completely": true, "highly": true,
+}
+
+type Result struct {
+	Score float64 // -1 (very negative) to +1 (very positive)
+	Label string  // Positive, Neutral or Negative
+}
+
+// Analyze scores the text using the bundled lexicon, it doesn't touch the network
+// ok is false when the text has no words at all
+func Analyze(text string) (result Result, ok bool) {
+	tokens := normalize.Tokens(text)
+	if len(tokens) == 0 {
+		return Result{}, false
+	}
+
+	sum := 0.0
+	negateFor := 0
+	boost := 1.0
+	for _, token := range tokens {
+		switch {
+		case negations[token]:
+			negateFor = negationScope
+			continue
+		case intensifiers[token]:
+			boost = intensifierBoost
+			continue
+		}
+
+		if value, found := lexicon[token]; found {
+			if negateFor > 0 {
+				// "not good" is bad but "not terrible" isn't great, so flipping is damped
+				value = -value * 0.75
+			}
+			sum += value * boost
+		}
+		boost = 1.0
+		if negateFor > 0 {
+			negateFor--
+		}
+	}
+
+	score := sum / math.Sqrt(sum*sum+alpha)
+	return Result{Score: math.Round(score*1000) / 1000, Label: label(score)}, true
+}
+
+// Mismatch is true when the star rating and the text disagree
+// e.g. a 5 star review that reads as negative
+func Mismatch(rating int8, label string) bool {
+	return (rating >= 4 && label == Negative) || (rating <= 2 && label == Positive)
+}
+
+func label(score float64) string {
+	switch {
+	case score >= threshold:
+		return Positive
+	case score <= -threshold:
+		return Negative
+	default:
+		return Neutral
+	}
+}
+
+func loadLexicon(file string) map[string]float64 {
+	words := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word, value, found := strings.Cut(line, "\t")
+		if !found {
+			panic("sentiment: malformed lexicon line: " + line)
+		}
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			panic("sentiment: malformed lexicon score: " + line)
+		}
+		words[word] = score
+	}
+	return words
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// anyone with the admin token can point a webhook anywhere, so deliveries must not be a way to
+// reach the database, the cloud metadata service or anything else on our own network
+
+var ErrPrivateAddress = errors.New("webhook: the address is not a public one")
+
+// carrier-grade NAT, IsPrivate doesn't cover it
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// PublicIP reports whether deliveries can be sent to ip, loopback, private, link-local
+// (169.254.169.254 is the metadata service), multicast and unspecified addresses can't
+func PublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// CheckHost fails unless every address the host of a webhook URL resolves to is public
+// (an IP literal is checked as it is)
+func CheckHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !PublicIP(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("webhook: resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// NewClient is the client deliveries are sent with
+// redirects aren't followed (a 3xx is just an unsuccessful response) and the address is
+// checked again when it's dialed, the DNS answer can change after the URL was accepted
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicIP(addrPort.Addr()) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// with a proxy the dialed address would be the proxy's and not the receiver's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every delivery carries a signature header so the receiver can check it came from us and
+// wasn't changed on the way:
+//
+//	X-Webhook-Signature: t=1700000000,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// v1 is the hex HMAC-SHA256 of "<t>.<body>" keyed with the subscription's secret, the timestamp
+// is part of what's signed so an old delivery can't be replayed with a new timestamp
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign gives the value of the signature header for a body sent at the given time
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signature(secret, timestamp, body))
+}
+
+// Verify checks a signature header the way a receiver would, tolerance is how old the timestamp
+// can be (0 doesn't check it)
+func Verify(secret, header string, body []byte, tolerance time.Duration) bool {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+	if tolerance > 0 && time.Since(time.Unix(unix, 0)).Abs() > tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body)))
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret makes a random secret for a subscription that didn't bring its own
+func NewSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Backoff is how long to wait before the next attempt after the given number of failed ones:
+// base, 2*base, 4*base... up to max
+func Backoff(failures int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
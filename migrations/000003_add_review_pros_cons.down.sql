@@ -0,0 +1,4 @@
+-- Filename: migrations/000003_add_review_pros_cons.down.sql
+ALTER TABLE review
+    DROP COLUMN IF EXISTS pros,
+    DROP COLUMN IF EXISTS cons;
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Filename: migrations/000003_add_review_pros_cons.up.sql
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS pros text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS cons text[] NOT NULL DEFAULT '{}';
\ No newline at end of file
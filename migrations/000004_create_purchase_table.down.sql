@@ -0,0 +1,6 @@
+-- Filename: migrations/000004_create_purchase_table.down.sql
+ALTER TABLE review
+    DROP COLUMN IF EXISTS author,
+    DROP COLUMN IF EXISTS verified_purchase;
+
+DROP TABLE IF EXISTS purchase;
\ No newline at end of file
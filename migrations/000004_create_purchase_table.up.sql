@@ -0,0 +1,21 @@
+-- Filename: migrations/000004_create_purchase_table.up.sql
+CREATE TABLE IF NOT EXISTS purchase (
+    order_id text NOT NULL,
+    customer text NOT NULL,
+    prod_id INTEGER NOT NULL,
+    variant text NOT NULL DEFAULT '',
+    purchased_at timestamp(0) WITH TIME ZONE NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (order_id, prod_id, variant),
+    CONSTRAINT fk_product
+    FOREIGN KEY (prod_id)
+    REFERENCES product (pid)
+    ON UPDATE CASCADE
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS purchase_customer_prod_idx ON purchase (customer, prod_id);
+
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS author text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS verified_purchase boolean NOT NULL DEFAULT false;
\ No newline at end of file
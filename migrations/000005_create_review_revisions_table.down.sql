@@ -0,0 +1,5 @@
+-- Filename: migrations/000005_create_review_revisions_table.down.sql
+ALTER TABLE review
+    DROP COLUMN IF EXISTS edited_at;
+
+DROP TABLE IF EXISTS review_revisions;
\ No newline at end of file
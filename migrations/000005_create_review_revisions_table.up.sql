@@ -0,0 +1,19 @@
+-- Filename: migrations/000005_create_review_revisions_table.up.sql
+CREATE TABLE IF NOT EXISTS review_revisions (
+    id bigserial PRIMARY KEY,
+    rid bigint NOT NULL,
+    revision int NOT NULL,
+    rating int NOT NULL,
+    pros text[] NOT NULL DEFAULT '{}',
+    cons text[] NOT NULL DEFAULT '{}',
+    replaced_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (rid, revision),
+    CONSTRAINT fk_review
+    FOREIGN KEY (rid)
+    REFERENCES review (rid)
+    ON UPDATE CASCADE
+    ON DELETE CASCADE
+);
+
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS edited_at timestamp(0) WITH TIME ZONE;
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- Filename: migrations/000006_add_soft_delete.down.sql
+DROP INDEX IF EXISTS product_deleted_at_idx;
+DROP INDEX IF EXISTS review_deleted_at_idx;
+
+ALTER TABLE product
+    DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE review
+    DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- Filename: migrations/000006_add_soft_delete.up.sql
+ALTER TABLE product
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) WITH TIME ZONE;
+
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) WITH TIME ZONE;
+
+-- the purge command looks rows up by deleted_at
+CREATE INDEX IF NOT EXISTS product_deleted_at_idx ON product (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS review_deleted_at_idx ON review (deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- Filename: migrations/000007_add_review_body_and_moderation.down.sql
+ALTER TABLE review_revisions
+    DROP COLUMN IF EXISTS body;
+
+DROP INDEX IF EXISTS review_fingerprint_bands_idx;
+DROP INDEX IF EXISTS review_status_idx;
+
+ALTER TABLE review
+    DROP CONSTRAINT IF EXISTS fk_duplicate_of,
+    DROP CONSTRAINT IF EXISTS review_status_check,
+    DROP COLUMN IF EXISTS body,
+    DROP COLUMN IF EXISTS fingerprint,
+    DROP COLUMN IF EXISTS fingerprint_bands,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS flag_reason,
+    DROP COLUMN IF EXISTS duplicate_of;
\ No newline at end of file
@@ -0,0 +1,21 @@
+-- Filename: migrations/000007_add_review_body_and_moderation.up.sql
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS body text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS fingerprint bigint,
+    ADD COLUMN IF NOT EXISTS fingerprint_bands bigint[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'published',
+    ADD COLUMN IF NOT EXISTS flag_reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS duplicate_of bigint,
+    ADD CONSTRAINT review_status_check
+    CHECK (status IN ('published', 'pending', 'rejected')),
+    ADD CONSTRAINT fk_duplicate_of
+    FOREIGN KEY (duplicate_of)
+    REFERENCES review (rid)
+    ON DELETE SET NULL;
+
+-- near-duplicate candidates are looked up by any matching band
+CREATE INDEX IF NOT EXISTS review_fingerprint_bands_idx ON review USING GIN (fingerprint_bands);
+CREATE INDEX IF NOT EXISTS review_status_idx ON review (status) WHERE status <> 'published';
+
+ALTER TABLE review_revisions
+    ADD COLUMN IF NOT EXISTS body text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Filename: migrations/000008_create_review_anomalies_table.down.sql
+DROP INDEX IF EXISTS review_prod_created_idx;
+
+DROP TABLE IF EXISTS review_anomalies;
\ No newline at end of file
@@ -0,0 +1,25 @@
+-- Filename: migrations/000008_create_review_anomalies_table.up.sql
+CREATE TABLE IF NOT EXISTS review_anomalies (
+    id bigserial PRIMARY KEY,
+    prod_id INTEGER NOT NULL,
+    kind text NOT NULL,
+    recent_count int NOT NULL,
+    recent_low_count int NOT NULL,
+    baseline_count int NOT NULL,
+    baseline_low_count int NOT NULL,
+    held_reviews int NOT NULL DEFAULT 1,
+    detected_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) WITH TIME ZONE,
+    CONSTRAINT fk_product
+    FOREIGN KEY (prod_id)
+    REFERENCES product (pid)
+    ON UPDATE CASCADE
+    ON DELETE CASCADE
+);
+
+-- a product only ever has one open alert, new bursts are added to it
+CREATE UNIQUE INDEX IF NOT EXISTS review_anomalies_open_idx ON review_anomalies (prod_id) WHERE resolved_at IS NULL;
+
+-- the detector counts recent reviews per product
+CREATE INDEX IF NOT EXISTS review_prod_created_idx ON review (prod_id, created_at);
\ No newline at end of file
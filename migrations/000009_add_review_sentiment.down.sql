@@ -0,0 +1,7 @@
+-- Filename: migrations/000009_add_review_sentiment.down.sql
+DROP INDEX IF EXISTS review_sentiment_idx;
+
+ALTER TABLE review
+    DROP COLUMN IF EXISTS sentiment_score,
+    DROP COLUMN IF EXISTS sentiment,
+    DROP COLUMN IF EXISTS sentiment_mismatch;
\ No newline at end of file
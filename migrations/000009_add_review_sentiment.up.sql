@@ -0,0 +1,7 @@
+-- Filename: migrations/000009_add_review_sentiment.up.sql
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS sentiment_score real,
+    ADD COLUMN IF NOT EXISTS sentiment text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS sentiment_mismatch boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS review_sentiment_idx ON review (sentiment);
\ No newline at end of file
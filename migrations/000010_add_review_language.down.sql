@@ -0,0 +1,8 @@
+-- Filename: migrations/000010_add_review_language.down.sql
+DROP INDEX IF EXISTS review_search_vector_idx;
+DROP INDEX IF EXISTS review_language_idx;
+
+ALTER TABLE review
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS search_config,
+    DROP COLUMN IF EXISTS language;
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- Filename: migrations/000010_add_review_language.up.sql
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS search_config regconfig NOT NULL DEFAULT 'simple',
+    ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector(search_config, body)) STORED;
+
+CREATE INDEX IF NOT EXISTS review_search_vector_idx ON review USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS review_language_idx ON review (language);
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- Filename: migrations/000011_add_version_columns.down.sql
+ALTER TABLE product
+    DROP COLUMN IF EXISTS version;
+
+ALTER TABLE review
+    DROP COLUMN IF EXISTS version;
\ No newline at end of file
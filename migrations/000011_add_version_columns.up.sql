@@ -0,0 +1,6 @@
+-- Filename: migrations/000011_add_version_columns.up.sql
+ALTER TABLE product
+    ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- Filename: migrations/000012_add_updated_at_columns.down.sql
+DROP TRIGGER IF EXISTS review_set_updated_at ON review;
+DROP TRIGGER IF EXISTS product_set_updated_at ON product;
+DROP FUNCTION IF EXISTS set_updated_at();
+
+ALTER TABLE review
+    DROP COLUMN IF EXISTS updated_at;
+
+ALTER TABLE product
+    DROP COLUMN IF EXISTS updated_at;
\ No newline at end of file
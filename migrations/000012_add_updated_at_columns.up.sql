@@ -0,0 +1,28 @@
+-- Filename: migrations/000012_add_updated_at_columns.up.sql
+ALTER TABLE product
+    ADD COLUMN IF NOT EXISTS updated_at timestamp WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS updated_at timestamp WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- full precision (not timestamp(0)) so two changes in the same second still get different ETags
+-- existing rows start out as last modified when they were created
+UPDATE product SET updated_at = created_at;
+UPDATE review SET updated_at = created_at;
+
+-- any change to a row (edits, avg_rating, helpful votes, moderation, soft deletes...) moves updated_at
+-- so the ETag and Last-Modified headers built from it always follow what the API returns
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_set_updated_at
+    BEFORE UPDATE ON product
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TRIGGER review_set_updated_at
+    BEFORE UPDATE ON review
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Filename: migrations/000013_create_idempotency_keys_table.down.sql
+DROP TABLE IF EXISTS idempotency_keys;
\ No newline at end of file
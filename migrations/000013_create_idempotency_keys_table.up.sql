@@ -0,0 +1,18 @@
+-- Filename: migrations/000013_create_idempotency_keys_table.up.sql
+-- responses to POST requests that were sent with an Idempotency-Key header
+-- status is NULL while the first request is still being handled
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key text NOT NULL,
+    method text NOT NULL,
+    path text NOT NULL,
+    request_hash text NOT NULL,
+    status integer,
+    headers jsonb NOT NULL DEFAULT '{}',
+    body bytea NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (idempotency_key, method, path)
+);
+
+-- used when clearing out expired keys
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Filename: migrations/000014_create_webhooks_tables.down.sql
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
\ No newline at end of file
@@ -0,0 +1,43 @@
+-- Filename: migrations/000014_create_webhooks_tables.up.sql
+-- subscriptions to the review and product events (see internal/data/events.go)
+-- the secret signs every delivery, see internal/webhook
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT TRUE,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+-- one row per event per subscription, status is pending until it's delivered (succeeded)
+-- or it ran out of attempts (failed)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id text NOT NULL,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at timestamp(0) WITH TIME ZONE
+);
+
+-- what the dispatcher looks for, and the delivery log of a subscription
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+
+-- every attempt at a delivery, status_code is NULL when there was no response at all
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+    attempted_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    status_code integer,
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- Filename: migrations/000015_create_outbox_table.down.sql
+DROP INDEX IF EXISTS webhook_deliveries_event_idx;
+DROP TABLE IF EXISTS outbox;
\ No newline at end of file
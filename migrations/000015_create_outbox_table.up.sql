@@ -0,0 +1,20 @@
+-- Filename: migrations/000015_create_outbox_table.up.sql
+-- events written in the same transaction as the change they describe, the relay publishes them
+-- to the sinks afterwards so an event can't be lost between the commit and the publish
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    event_id text NOT NULL UNIQUE,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error text NOT NULL DEFAULT '',
+    published_at timestamp(0) WITH TIME ZONE
+);
+
+-- what the relay looks for
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (next_attempt_at, id) WHERE published_at IS NULL;
+
+-- the relay can hand the same event to the webhooks more than once, it only becomes one delivery
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries (webhook_id, event_id);
\ No newline at end of file
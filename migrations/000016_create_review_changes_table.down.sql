@@ -0,0 +1,4 @@
+-- Filename: migrations/000016_create_review_changes_table.down.sql
+DROP TRIGGER IF EXISTS review_changes_trigger ON review;
+DROP FUNCTION IF EXISTS record_review_change();
+DROP TABLE IF EXISTS review_changes;
\ No newline at end of file
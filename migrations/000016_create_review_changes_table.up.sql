@@ -0,0 +1,28 @@
+-- Filename: migrations/000016_create_review_changes_table.up.sql
+-- every insert and update of a review gets a row here and a notification on the review_changes
+-- channel carrying its id, GET /v1/review/stream listens for them and the id is the event id
+-- a client sends back in Last-Event-ID to pick up what it missed
+CREATE TABLE IF NOT EXISTS review_changes (
+    id bigserial PRIMARY KEY,
+    rid bigint NOT NULL REFERENCES review ON DELETE CASCADE,
+    op text NOT NULL,
+    changed_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- used when clearing out old changes
+CREATE INDEX IF NOT EXISTS review_changes_changed_at_idx ON review_changes (changed_at);
+
+CREATE OR REPLACE FUNCTION record_review_change() RETURNS trigger AS $$
+DECLARE
+    change_id bigint;
+BEGIN
+    INSERT INTO review_changes (rid, op) VALUES (NEW.rid, lower(TG_OP)) RETURNING id INTO change_id;
+    -- delivered when the transaction commits, nothing is sent for a rolled back change
+    PERFORM pg_notify('review_changes', change_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER review_changes_trigger
+    AFTER INSERT OR UPDATE ON review
+    FOR EACH ROW EXECUTE FUNCTION record_review_change();
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- Filename: migrations/000017_add_purchase_review_tokens.down.sql
+ALTER TABLE review
+    DROP COLUMN IF EXISTS purchase_token_hash,
+    DROP COLUMN IF EXISTS variant;
+
+DROP INDEX IF EXISTS purchase_review_token_idx;
+
+ALTER TABLE purchase
+    DROP COLUMN IF EXISTS review_token_hash;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- Filename: migrations/000017_add_purchase_review_tokens.up.sql
+-- the storefront hands the customer a review token with the order, a review only gets the
+-- verified purchase badge when it brings the token of a purchase of the product (the author is
+-- just a string anyone can send), only a hash of the token is kept on either side
+ALTER TABLE purchase
+    ADD COLUMN IF NOT EXISTS review_token_hash text;
+
+CREATE INDEX IF NOT EXISTS purchase_review_token_idx ON purchase (review_token_hash) WHERE review_token_hash IS NOT NULL;
+
+-- the variant that was reviewed, empty when the review is about the product as a whole
+ALTER TABLE review
+    ADD COLUMN IF NOT EXISTS variant text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS purchase_token_hash text;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Filename: migrations/000018_add_review_changes_xid.down.sql
+DROP INDEX IF EXISTS review_changes_xid_id_idx;
+
+ALTER TABLE review_changes
+    DROP COLUMN IF EXISTS xid;
\ No newline at end of file
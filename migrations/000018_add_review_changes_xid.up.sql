@@ -0,0 +1,10 @@
+-- Filename: migrations/000018_add_review_changes_xid.up.sql
+-- the ids of review_changes come from a sequence so they're taken in the order the changes were
+-- made and not the order their transactions commit, a reader that went past id 6 while the
+-- transaction of id 5 was still open would never see 5
+-- the transaction of each change is kept so the stream can go by transaction instead and hold
+-- a change back until every transaction before it is over (pg_current_xact_id needs postgres 13)
+ALTER TABLE review_changes
+    ADD COLUMN IF NOT EXISTS xid bigint NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+
+CREATE INDEX IF NOT EXISTS review_changes_xid_id_idx ON review_changes (xid, id);
\ No newline at end of file